@@ -1,30 +1,77 @@
 package main
 
 import (
+	"fmt"
 	"github.com/codegangsta/cli"
 	"github.com/hata/gorep/book"
 	"log"
 	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type AppOptions struct {
 	afterContext      int
+	allMatch          bool
+	basicRegexp       bool
 	beforeContext     int
+	cidr              string
+	columns           string
 	context           int
 	count             bool
+	csv               bool
+	date              string
+	delimiter         string
+	expr              string
+	fields            []string
+	extendedRegexp    bool
 	fixedStrings      bool
 	file              string
+	header            bool
+	hex               []string
 	withFilename      bool
 	noFilename        bool
 	ignoreCase        bool
+	smartCase         bool
+	invertMatch       bool
+	json              string
+	jsonInvalid       bool
+	labeledPatterns   bool
 	filesWithoutMatch bool
 	filesWithMatches  bool
 	lineNumber        bool
+	maxCount          int
+	maxErrors         int
+	multiline         bool
+	num               string
+	onlyMatching      bool
+	perlRegexp        bool
+	preset            []string
+	presetDir         string
 	quiet             bool
+	tsv               bool
+	until             string
 	recursive         bool
+	since             string
+	sorted            bool
+	timeLayout        string
+	patternLabel      bool
+	wordRegexp        bool
+	lineRegexp        bool
 
 	// Created from command line options.
 	patterns         []string
+	patternLabels    []string
+	expression       *book.Expression
+	typedMatchers    []book.TypedMatcher
+	fieldParams      *book.FieldParams
+	jsonParams       *book.JSONParams
+	logfmtParams     *book.LogfmtParams
+	timeRangeParams  *book.TimeRangeParams
+	hexPatterns      []*book.HexPattern
+	usesPresets      bool
+	ignoreCases      []bool
 	files            []string
 	showFilenameFlag bool
 }
@@ -33,20 +80,53 @@ var appOptions AppOptions
 
 var Flags = []cli.Flag{
 	flagAfterContext,
+	flagAllMatch,
+	flagBasicRegexp,
 	flagBeforeContext,
+	flagCIDR,
+	flagColumns,
 	flagContext,
 	flagCount,
+	flagCSV,
+	flagDate,
+	flagDelimiter,
+	flagExpression,
+	flagExtendedRegexp,
+	flagField,
 	flagFixedStrings,
 	flagFile,
 	flagWithFilename,
 	flagNoFilename,
+	flagHeader,
+	flagHex,
 	//    flagHelp,
 	flagIgnoreCase,
+	flagSmartCase,
+	flagInvertMatch,
+	flagJSON,
+	flagJSONInvalid,
+	flagLabeledPatterns,
 	flagFilesWithoutMatch,
 	flagFilesWithMatches,
 	flagLineNumber,
+	flagMaxCount,
+	flagMaxErrors,
+	flagMultiline,
+	flagNum,
+	flagOnlyMatching,
+	flagPerlRegexp,
+	flagPreset,
+	flagPresetDir,
 	flagQuiet,
+	flagTSV,
 	flagRecursive,
+	flagSince,
+	flagSorted,
+	flagTimeLayout,
+	flagUntil,
+	flagPatternLabel,
+	flagWordRegexp,
+	flagLineRegexp,
 	//    flagVersion,
 }
 
@@ -55,11 +135,31 @@ var flagAfterContext = cli.IntFlag{
 	Usage: "Print num lines of trailing context after each match. See also the -B and -C options",
 }
 
+var flagAllMatch = cli.BoolFlag{
+	Name:  "all-match",
+	Usage: "Select files only if every pattern matches a line in the file.",
+}
+
+var flagBasicRegexp = cli.BoolFlag{
+	Name:  "basic-regexp, G",
+	Usage: "Interpret pattern as a POSIX basic regular expression.",
+}
+
 var flagBeforeContext = cli.IntFlag{
 	Name:  "before-context, B",
 	Usage: "Print num lines of leading context before each match. See also the -A and -C options.",
 }
 
+var flagCIDR = cli.StringFlag{
+	Name:  "cidr",
+	Usage: "Select lines which have an IP address in comma separated networks. e.g. '10.0.0.0/8,::1'",
+}
+
+var flagColumns = cli.StringFlag{
+	Name:  "columns",
+	Usage: "Match patterns with comma separated columns of delimited lines. A column is a 1-based index or a name in the header line. e.g. '2,status'",
+}
+
 var flagContext = cli.IntFlag{
 	Name:  "context, C",
 	Usage: "Print num lines of leading and trailing context surrounding each match.",
@@ -70,6 +170,37 @@ var flagCount = cli.BoolFlag{
 	Usage: "Only a count of selected lines is written to standard output.",
 }
 
+var flagCSV = cli.BoolFlag{
+	Name:  "csv",
+	Usage: "Split lines into fields by ',' with CSV quoting rules. Matched fields are printed before lines. Quoted fields with newlines are not supported.",
+}
+
+var flagDate = cli.StringFlag{
+	Name:  "date",
+	Usage: "Select lines which have a date or a timestamp in comma separated ranges. e.g. '2024-01-01..2024-01-31'",
+}
+
+var flagDelimiter = cli.StringFlag{
+	Name:  "delimiter",
+	Usage: "Split lines into fields by a delimiter character with CSV quoting rules. '\\t' is a tab. Quoted fields with newlines are not supported.",
+}
+
+var flagExpression = cli.StringFlag{
+	Name:  "expr",
+	Usage: "Select lines by boolean expression of patterns with --and, --or, --not and parentheses. e.g. 'foo --and --not ( bar --or -e -baz )'",
+}
+
+var flagExtendedRegexp = cli.BoolFlag{
+	Name:  "extended-regexp, E",
+	Usage: "Interpret pattern as a POSIX extended regular expression.",
+}
+
+var flagField = cli.StringSliceFlag{
+	Name:  "field",
+	Value: &cli.StringSlice{},
+	Usage: "Select logfmt lines which have key=value or a value of key matching key~regexp. All of --field options must be satisfied. e.g. --field level=error --field msg~timeout",
+}
+
 var flagFixedStrings = cli.BoolFlag{
 	Name:  "fixed-strings, F",
 	Usage: "Interpret pattern as a set of fixed strings",
@@ -90,6 +221,17 @@ var flagNoFilename = cli.BoolFlag{
 	Usage: "Never print filename headers (i.e. filenames) with output lines.",
 }
 
+var flagHeader = cli.BoolFlag{
+	Name:  "header",
+	Usage: "Skip the first line of delimited lines as a header. Fields are printed with their names.",
+}
+
+var flagHex = cli.StringSliceFlag{
+	Name:  "hex",
+	Value: &cli.StringSlice{},
+	Usage: "Search raw bytes of files for a hex byte sequence and print byte offsets. '??' matches any byte. Options for lines are not used. e.g. 'CA FE ?? BE'",
+}
+
 /*
 var flagHelp = cli.Flag{
 	Name:  "help",
@@ -101,6 +243,31 @@ var flagIgnoreCase = cli.BoolFlag{
 	Usage: "Perform case insensitive matching.  By default, grep is case sensitive.",
 }
 
+var flagSmartCase = cli.BoolFlag{
+	Name:  "smart-case, S",
+	Usage: "Search case insensitively if a pattern is all lowercase. Otherwise, search the pattern case sensitively.",
+}
+
+var flagInvertMatch = cli.BoolFlag{
+	Name:  "invert-match, v",
+	Usage: "Selected lines are those not matching any of the specified patterns.",
+}
+
+var flagJSON = cli.StringFlag{
+	Name:  "json",
+	Usage: "Parse lines as JSON and match patterns with values at comma separated paths. Lines which aren't JSON are skipped. e.g. '.level,.req.path,.tags[]'",
+}
+
+var flagJSONInvalid = cli.BoolFlag{
+	Name:  "json-invalid",
+	Usage: "Select lines which aren't JSON with --json and print them with 'invalid JSON'.",
+}
+
+var flagLabeledPatterns = cli.BoolFlag{
+	Name:  "labeled-patterns",
+	Usage: "A line like 'label<TAB>pattern' in -f file gives a label to the pattern.",
+}
+
 var flagFilesWithoutMatch = cli.BoolFlag{
 	Name:  "files-without-match, L",
 	Usage: "Only the names of files not containing selected lines are written to standard output.",
@@ -116,16 +283,98 @@ var flagLineNumber = cli.BoolFlag{
 	Usage: "Each output line is preceded by its relative line number in the file, starting at line 1.",
 }
 
+var flagMaxCount = cli.IntFlag{
+	Name:  "max-count, m",
+	Usage: "Stop reading a file after num selected lines. Context after the last line is still printed.",
+}
+
+var flagMaxErrors = cli.IntFlag{
+	Name:  "max-errors, k",
+	Usage: "Match fixed string patterns approximately with at most num insertions, deletions and substitutions.",
+}
+
+var flagMultiline = cli.BoolFlag{
+	Name:  "multiline, U",
+	Usage: "Allow matches to span multiple lines. A pattern can match '\\n' and all matched lines are printed.",
+}
+
+var flagNum = cli.StringFlag{
+	Name:  "num",
+	Usage: "Select lines which have a number in comma separated ranges. e.g. '500..599,404'",
+}
+
+var flagOnlyMatching = cli.BoolFlag{
+	Name:  "only-matching, o",
+	Usage: "Prints only the matching part of the lines.",
+}
+
+var flagPerlRegexp = cli.BoolFlag{
+	Name:  "perl-regexp, P",
+	Usage: "Interpret pattern as a Perl compatible regular expression with lookaround and backreferences.",
+}
+
+var flagPreset = cli.StringSliceFlag{
+	Name:  "preset",
+	Value: &cli.StringSlice{},
+	Usage: "Use a named pattern. Its name is the label of the pattern. '@preset:name' in a -f file is also a preset. Presets: " +
+		strings.Join(book.NewPresets().Names(), ", "),
+}
+
+var flagPresetDir = cli.StringFlag{
+	Name:  "preset-dir",
+	Usage: "Add presets in a directory. Each file is a preset named by the file name without its extension and has a pattern in the first line.",
+}
+
 var flagQuiet = cli.BoolFlag{
 	Name:  "quiet, q",
 	Usage: "Quiet mode: suppress normal output.",
 }
 
+var flagTSV = cli.BoolFlag{
+	Name:  "tsv",
+	Usage: "Split lines into fields by tabs with CSV quoting rules. Matched fields are printed before lines. Quoted fields with newlines are not supported.",
+}
+
 var flagRecursive = cli.BoolFlag{
 	Name:  "recursive, r",
 	Usage: "Recursively search subdirectories listed.",
 }
 
+var flagSince = cli.StringFlag{
+	Name:  "since",
+	Usage: "Select lines which have a timestamp at or after a date or a time. Timestamps are RFC3339, Apache, syslog or --time-layout. e.g. '2024-01-31T10:00:00Z'",
+}
+
+var flagSorted = cli.BoolFlag{
+	Name:  "sorted",
+	Usage: "Stop reading a file at a timestamp after --until. Lines must be sorted by their timestamps.",
+}
+
+var flagTimeLayout = cli.StringFlag{
+	Name:  "time-layout",
+	Usage: "Go time layout of timestamps for --since and --until. e.g. '2006/01/02 15:04:05'",
+}
+
+var flagUntil = cli.StringFlag{
+	Name:  "until",
+	Usage: "Select lines which have a timestamp at or before a date or a time. A date covers the whole day.",
+}
+
+var flagPatternLabel = cli.BoolFlag{
+	Name:  "pattern-label",
+	Usage: "Prefix each output line with labels of patterns which matched the line.",
+}
+
+var flagWordRegexp = cli.BoolFlag{
+	Name:  "word-regexp, w",
+	Usage: "Select only lines containing matches that form whole words.",
+}
+
+var flagLineRegexp = cli.BoolFlag{
+	Name:  "line-regexp, x",
+	Usage: "Select only those matches that exactly match the whole line.",
+}
+
 /*
 var flagVersion = cli.Flag{
 	Name:  "version",
@@ -134,28 +383,162 @@ var flagVersion = cli.Flag{
 
 func flagAction(c *cli.Context) {
 	appOptions.afterContext = c.Int("after-context")
+	appOptions.allMatch = c.Bool("all-match")
+	appOptions.basicRegexp = c.Bool("basic-regexp")
 	appOptions.beforeContext = c.Int("before-context")
+	appOptions.cidr = c.String("cidr")
 	appOptions.context = c.Int("context")
+	appOptions.columns = c.String("columns")
 	appOptions.count = c.Bool("count")
+	appOptions.csv = c.Bool("csv")
+	appOptions.date = c.String("date")
+	appOptions.delimiter = c.String("delimiter")
+	appOptions.expr = c.String("expr")
+	appOptions.extendedRegexp = c.Bool("extended-regexp")
+	appOptions.fields = c.StringSlice("field")
 	appOptions.fixedStrings = c.Bool("fixed-strings")
 	appOptions.file = c.String("file")
+	appOptions.header = c.Bool("header")
+	appOptions.hex = c.StringSlice("hex")
 	appOptions.withFilename = c.Bool("with-filename")
 	appOptions.noFilename = c.Bool("no-filename")
 	appOptions.ignoreCase = c.Bool("ignore-case")
+	appOptions.smartCase = c.Bool("smart-case")
+	appOptions.invertMatch = c.Bool("invert-match")
+	appOptions.json = c.String("json")
+	appOptions.jsonInvalid = c.Bool("json-invalid")
+	appOptions.labeledPatterns = c.Bool("labeled-patterns")
 	appOptions.filesWithoutMatch = c.Bool("files-without-match")
 	appOptions.filesWithMatches = c.Bool("files-with-matches")
 	appOptions.lineNumber = c.Bool("line-number")
+	appOptions.maxCount = c.Int("max-count")
+	appOptions.maxErrors = c.Int("max-errors")
+	appOptions.multiline = c.Bool("multiline")
+	appOptions.num = c.String("num")
+	appOptions.onlyMatching = c.Bool("only-matching")
+	appOptions.perlRegexp = c.Bool("perl-regexp")
+	appOptions.preset = c.StringSlice("preset")
+	appOptions.presetDir = c.String("preset-dir")
 	appOptions.quiet = c.Bool("quiet")
+	appOptions.tsv = c.Bool("tsv")
 	appOptions.recursive = c.Bool("recursive")
+	appOptions.since = c.String("since")
+	appOptions.sorted = c.Bool("sorted")
+	appOptions.timeLayout = c.String("time-layout")
+	appOptions.until = c.String("until")
+	appOptions.patternLabel = c.Bool("pattern-label")
+	appOptions.wordRegexp = c.Bool("word-regexp")
+	appOptions.lineRegexp = c.Bool("line-regexp")
+
+	presets := book.NewPresets()
+	if len(appOptions.presetDir) > 0 {
+		if err := presets.LoadDir(appOptions.presetDir); err != nil {
+			fmt.Println("Failed to load presets.", err)
+			return
+		}
+	}
 
-	if len(appOptions.file) > 0 {
-		appOptions.patterns = readPatternsFile(appOptions.file)
+	if len(appOptions.expr) > 0 {
+		expr, patterns, err := book.ParseExpression(appOptions.expr)
+		if err != nil {
+			fmt.Println("Failed to parse expression.", err)
+			return
+		}
+		appOptions.expression = expr
+		appOptions.patterns = patterns
+	} else if len(appOptions.file) > 0 {
+		patterns, labels, usesPresets, err := readPatternsFile(appOptions.file, presets, appOptions.labeledPatterns)
+		if err != nil {
+			fmt.Println("Failed to read patterns.", err)
+			return
+		}
+		appOptions.patterns, appOptions.patternLabels = patterns, labels
+		appOptions.usesPresets = usesPresets
+	}
+
+	if len(appOptions.preset) > 0 {
+		labels := make([]string, len(appOptions.patterns), len(appOptions.patterns)+len(appOptions.preset))
+		copy(labels, appOptions.patternLabels)
+		for _, name := range appOptions.preset {
+			pattern, err := presets.Pattern(name)
+			if err != nil {
+				fmt.Println("Failed to find a preset.", err)
+				return
+			}
+			appOptions.patterns = append(appOptions.patterns, pattern)
+			labels = append(labels, name)
+		}
+		appOptions.patternLabels = labels
+		appOptions.usesPresets = true
+	}
+
+	typedMatchers, err := newTypedMatchers(appOptions.num, appOptions.cidr, appOptions.date)
+	if err != nil {
+		fmt.Println("Failed to parse a typed matcher.", err)
+		return
+	}
+	appOptions.typedMatchers = typedMatchers
+
+	fieldParams, err := newFieldParams()
+	if err != nil {
+		fmt.Println("Failed to parse field options.", err)
+		return
+	}
+	appOptions.fieldParams = fieldParams
+
+	if len(appOptions.json) > 0 {
+		paths, err := book.ParseJSONPaths(appOptions.json)
+		if err != nil {
+			fmt.Println("Failed to parse --json.", err)
+			return
+		}
+		appOptions.jsonParams = &book.JSONParams{Paths: paths, ReportInvalid: appOptions.jsonInvalid}
+	}
+
+	if len(appOptions.fields) > 0 {
+		logfmtParams := new(book.LogfmtParams)
+		for _, field := range appOptions.fields {
+			condition, err := book.ParseLogfmtCondition(field)
+			if err != nil {
+				fmt.Println("Failed to parse --field.", err)
+				return
+			}
+			logfmtParams.Conditions = append(logfmtParams.Conditions, condition)
+		}
+		appOptions.logfmtParams = logfmtParams
+	}
+
+	if len(appOptions.hex) > 0 {
+		var hexPatterns []*book.HexPattern
+		for _, text := range appOptions.hex {
+			p, err := book.ParseHexPattern(text)
+			if err != nil {
+				fmt.Println("Failed to parse --hex.", err)
+				return
+			}
+			hexPatterns = append(hexPatterns, p)
+		}
+		appOptions.hexPatterns = hexPatterns
+	}
+
+	if len(appOptions.since) > 0 || len(appOptions.until) > 0 {
+		timeRangeParams, err := book.ParseTimeRange(appOptions.since, appOptions.until)
+		if err != nil {
+			fmt.Println("Failed to parse --since and --until.", err)
+			return
+		}
+		timeRangeParams.Layout = appOptions.timeLayout
+		timeRangeParams.Sorted = appOptions.sorted
+		appOptions.timeRangeParams = timeRangeParams
 	}
 
 	length := len(c.Args())
 	if length > 0 {
 		startFileIndex := 0
-		if appOptions.patterns == nil {
+		// Typed matchers, --field, --since, --until and --hex replace the
+		// pattern argument. Use -f or --expr to give patterns with them.
+		if appOptions.patterns == nil && appOptions.typedMatchers == nil && appOptions.logfmtParams == nil &&
+			appOptions.timeRangeParams == nil && appOptions.hexPatterns == nil {
 			appOptions.patterns = make([]string, 1)
 			appOptions.patterns[0] = c.Args()[0]
 			startFileIndex++
@@ -177,6 +560,21 @@ func flagAction(c *cli.Context) {
 		return
 	}
 
+	if appOptions.smartCase && !appOptions.ignoreCase {
+		appOptions.ignoreCases = make([]bool, len(appOptions.patterns))
+		for i, p := range appOptions.patterns {
+			appOptions.ignoreCases[i] = !hasUpperCaseLiteral(p, appOptions.fixedStrings)
+		}
+		if appOptions.logfmtParams != nil {
+			conditions := appOptions.logfmtParams.Conditions
+			appOptions.logfmtParams.IgnoreCases = make([]bool, len(conditions))
+			for i, condition := range conditions {
+				fixedStrings := !condition.Regexp || appOptions.fixedStrings
+				appOptions.logfmtParams.IgnoreCases[i] = !hasUpperCaseLiteral(condition.Value, fixedStrings)
+			}
+		}
+	}
+
 	if appOptions.context > 0 && appOptions.afterContext == 0 && appOptions.beforeContext == 0 {
 		appOptions.afterContext = appOptions.context
 		appOptions.beforeContext = appOptions.context
@@ -217,19 +615,169 @@ func isDir(path string) (bool, error) {
 	}
 }
 
-func readPatternsFile(path string) []string {
-	patterns := make([]string, 0, 10)
+// hasUpperCaseLiteral checks pattern has an upper case letter. For regexp
+// patterns, letters in escape sequences (e.g. \S, \p{Lu}) and group names
+// are not counted.
+func hasUpperCaseLiteral(pattern string, fixedStrings bool) bool {
+	for i := 0; i < len(pattern); {
+		r, size := utf8.DecodeRuneInString(pattern[i:])
+		i += size
+		if fixedStrings {
+			if unicode.IsUpper(r) {
+				return true
+			}
+			continue
+		}
 
-	input, err := book.NewFileInput(path)
-	defer input.Close()
+		switch {
+		case r == '\\' && i < len(pattern):
+			r, size = utf8.DecodeRuneInString(pattern[i:])
+			i += size
+			if (r == 'p' || r == 'P' || r == 'x') && strings.HasPrefix(pattern[i:], "{") {
+				if end := strings.IndexByte(pattern[i:], '}'); end >= 0 {
+					i += end + 1
+				}
+			} else if (r == 'p' || r == 'P') && i < len(pattern) {
+				i++
+			}
+		case r == '(' && strings.HasPrefix(pattern[i:], "?P<"):
+			if end := strings.IndexByte(pattern[i:], '>'); end >= 0 {
+				i += end + 1
+			}
+		case unicode.IsUpper(r):
+			return true
+		}
+	}
+	return false
+}
+
+// translatePosixPatterns converts POSIX basic or extended regexp patterns to
+// Go regexp syntax. The original patterns are kept as labels if they don't
+// have labels.
+func translatePosixPatterns(appOptions *AppOptions) error {
+	translate := book.TranslateBasicRegexp
+	if appOptions.extendedRegexp {
+		translate = book.TranslateExtendedRegexp
+	}
+
+	labels := make([]string, len(appOptions.patterns))
+	copy(labels, appOptions.patternLabels)
+	for i, p := range appOptions.patterns {
+		translated, err := translate(p)
+		if err != nil {
+			return err
+		}
+		if labels[i] == "" {
+			labels[i] = p
+		}
+		appOptions.patterns[i] = translated
+	}
+	appOptions.patternLabels = labels
+	return nil
+}
+
+// newTypedMatchers creates typed matchers for --num, --cidr and --date. It
+// returns nil if they are not set.
+func newTypedMatchers(num, cidr, date string) ([]book.TypedMatcher, error) {
+	var typedMatchers []book.TypedMatcher
+	specs := []struct {
+		spec       string
+		newMatcher func(string) (book.TypedMatcher, error)
+	}{
+		{num, book.NewNumberRangeMatcher},
+		{cidr, book.NewCIDRMatcher},
+		{date, book.NewDateRangeMatcher},
+	}
+	for _, s := range specs {
+		if len(s.spec) == 0 {
+			continue
+		}
+		m, err := s.newMatcher(s.spec)
+		if err != nil {
+			return nil, err
+		}
+		typedMatchers = append(typedMatchers, m)
+	}
+	return typedMatchers, nil
+}
+
+// newFieldParams creates FieldParams for --csv, --tsv, --delimiter,
+// --columns and --header. It returns nil if none of them is set.
+func newFieldParams() (*book.FieldParams, error) {
+	if !appOptions.csv && !appOptions.tsv && appOptions.delimiter == "" &&
+		appOptions.columns == "" && !appOptions.header {
+		return nil, nil
+	}
 
+	params := &book.FieldParams{Delimiter: ',', Header: appOptions.header}
+	switch {
+	case appOptions.delimiter == "\\t":
+		params.Delimiter = '\t'
+	case len(appOptions.delimiter) == 1:
+		params.Delimiter = appOptions.delimiter[0]
+	case appOptions.delimiter != "":
+		return nil, fmt.Errorf("delimiter must be a character: %q", appOptions.delimiter)
+	case appOptions.tsv:
+		params.Delimiter = '\t'
+	}
+	if appOptions.columns != "" {
+		columns, err := book.ParseColumns(appOptions.columns)
+		if err != nil {
+			return nil, err
+		}
+		params.Columns = columns
+	}
+	return params, nil
+}
+
+// readPatternsFile reads patterns and their labels. When labeled is set, a
+// line which starts with a label consisting of letters, digits, '_', '-'
+// and '.' followed by a tab is a labeled pattern. A pattern '@preset:name'
+// is replaced with the preset and its label is name unless it has a label.
+// usesPresets is set when the file has a preset.
+func readPatternsFile(path string, presets *book.Presets, labeled bool) (patterns, labels []string, usesPresets bool, err error) {
+	patterns = make([]string, 0, 10)
+	labels = make([]string, 0, 10)
+
+	input, err := book.NewFileInput(path)
 	if err != nil {
-		return nil
+		return nil, nil, false, err
 	}
+	defer input.Close()
 
 	input.EachLineBytes(func(text []byte) {
-		patterns = append(patterns, string(text))
+		label, pattern := "", string(text)
+		if labeled {
+			label, pattern = splitPatternLabel(pattern)
+		}
+		if strings.HasPrefix(pattern, book.PresetPrefix) {
+			name := pattern[len(book.PresetPrefix):]
+			presetPattern, presetErr := presets.Pattern(name)
+			if presetErr != nil && err == nil {
+				err = presetErr
+			}
+			pattern = presetPattern
+			if label == "" {
+				label = name
+			}
+			usesPresets = true
+		}
+		patterns = append(patterns, pattern)
+		labels = append(labels, label)
 	})
 
-	return patterns
+	return
+}
+
+func splitPatternLabel(line string) (string, string) {
+	n := strings.IndexByte(line, '\t')
+	if n <= 0 {
+		return "", line
+	}
+	for _, r := range line[:n] {
+		if !(r == '_' || r == '-' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return "", line
+		}
+	}
+	return line[:n], line[n+1:]
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/hata/gorep/book"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPatternsFileLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "patterns")
+	ioutil.WriteFile(path, []byte("err\tfoo\nbar\n"), 0644)
+
+	patterns, labels, _, err := readPatternsFile(path, book.NewPresets(), false)
+	if err != nil || len(patterns) != 2 || patterns[0] != "err\tfoo" || labels[0] != "" {
+		t.Error("A tab shouldn't be a label separator by default.", patterns, labels, err)
+	}
+	patterns, labels, _, err = readPatternsFile(path, book.NewPresets(), true)
+	if err != nil || len(patterns) != 2 || patterns[0] != "foo" || labels[0] != "err" || labels[1] != "" {
+		t.Error("A label should be read when labeled is set.", patterns, labels, err)
+	}
+}
+
+func TestReadPatternsFileNotFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	patterns, _, _, err := readPatternsFile(filepath.Join(dir, "missing"), book.NewPresets(), false)
+	if err == nil || patterns != nil {
+		t.Error("readPatternsFile should return an error when the file can't be opened.", patterns, err)
+	}
+}
+
+func TestReadPatternsFilePresets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "patterns")
+	ioutil.WriteFile(path, []byte("foo\n"+book.PresetPrefix+"uuid\n"), 0644)
+
+	appOptions = AppOptions{}
+	patterns, labels, usesPresets, err := readPatternsFile(path, book.NewPresets(), false)
+	if err != nil || len(patterns) != 2 || labels[1] != "uuid" || !usesPresets {
+		t.Error("A preset should be read with its name as the label.", patterns, labels, usesPresets, err)
+	}
+	if appOptions.usesPresets {
+		t.Error("readPatternsFile shouldn't change appOptions.")
+	}
+}
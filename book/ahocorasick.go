@@ -0,0 +1,194 @@
+package book
+
+import (
+	"sort"
+)
+
+const (
+	// Fixed strings matcher uses Aho-Corasick when the number of patterns
+	// is equal or larger than this.
+	ahoCorasickPatternThreshold = 16
+
+	acRootState = int32(0)
+	acNoState   = int32(-1)
+)
+
+// Aho-Corasick automaton to find many fixed patterns by scanning a line once.
+type ahoCorasick struct {
+	states         []acState
+	rootNext       [256]int32
+	patternLengths []int
+	hasEmpty       bool
+	emptyIndexes   []int
+}
+
+type acState struct {
+	labels  []byte
+	targets []int32
+	fail    int32
+	// The nearest state on the fail links which has outputs.
+	dict int32
+	// Indexes of patterns which end at this state.
+	outputs []int
+}
+
+// newAhoCorasick creates an automaton for patterns. Bytes are compared case
+// sensitively.
+func newAhoCorasick(patterns [][]byte) *ahoCorasick {
+	a := new(ahoCorasick)
+	a.patternLengths = make([]int, len(patterns))
+	a.states = make([]acState, 1, 1+len(patterns)*4)
+	a.states[acRootState] = acState{fail: acRootState, dict: acNoState}
+
+	for i, p := range patterns {
+		a.patternLengths[i] = len(p)
+		if len(p) == 0 {
+			a.hasEmpty = true
+			a.emptyIndexes = append(a.emptyIndexes, i)
+		}
+		state := acRootState
+		for _, b := range p {
+			next, ok := a.transition(state, b)
+			if !ok || (state == acRootState && next == acRootState) {
+				next = int32(len(a.states))
+				a.states = append(a.states, acState{dict: acNoState})
+				a.addTransition(state, b, next)
+			}
+			state = next
+		}
+		a.states[state].outputs = append(a.states[state].outputs, i)
+	}
+
+	a.buildFailLinks()
+	return a
+}
+
+func (a *ahoCorasick) addTransition(state int32, b byte, next int32) {
+	if state == acRootState {
+		a.rootNext[b] = next
+	}
+	s := &a.states[state]
+	s.labels = append(s.labels, b)
+	s.targets = append(s.targets, next)
+}
+
+func (a *ahoCorasick) transition(state int32, b byte) (int32, bool) {
+	if state == acRootState {
+		return a.rootNext[b], true
+	}
+	s := &a.states[state]
+	for i, label := range s.labels {
+		if label == b {
+			return s.targets[i], true
+		}
+	}
+	return acNoState, false
+}
+
+// buildFailLinks sets fail and dict links by breadth first search.
+func (a *ahoCorasick) buildFailLinks() {
+	queue := make([]int32, 0, len(a.states))
+	for _, child := range a.states[acRootState].targets {
+		a.states[child].fail = acRootState
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		s := &a.states[state]
+		for i, label := range s.labels {
+			child := s.targets[i]
+			fail := a.next(s.fail, label)
+			a.states[child].fail = fail
+			if len(a.states[fail].outputs) > 0 {
+				a.states[child].dict = fail
+			} else {
+				a.states[child].dict = a.states[fail].dict
+			}
+			queue = append(queue, child)
+		}
+	}
+}
+
+func (a *ahoCorasick) next(state int32, b byte) int32 {
+	for {
+		if next, ok := a.transition(state, b); ok {
+			return next
+		}
+		state = a.states[state].fail
+	}
+}
+
+// eachMatch calls handler with the pattern index and the end position of
+// every occurrence of patterns in textBytes until handler returns false.
+// Occurrences of empty patterns are not reported.
+func (a *ahoCorasick) eachMatch(textBytes []byte, handler func(patternIndex, end int) bool) {
+	state := acRootState
+	for i, b := range textBytes {
+		state = a.next(state, b)
+		for out := state; out != acNoState; out = a.states[out].dict {
+			for _, patternIndex := range a.states[out].outputs {
+				if a.patternLengths[patternIndex] > 0 && !handler(patternIndex, i+1) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *ahoCorasick) Match(textBytes []byte) bool {
+	if a.hasEmpty {
+		return true
+	}
+	found := false
+	a.eachMatch(textBytes, func(patternIndex, end int) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// patternIndexes returns indexes of patterns which occur in textBytes in
+// ascending order. Empty patterns always occur.
+func (a *ahoCorasick) patternIndexes(textBytes []byte) []int {
+	indexes := append([]int(nil), a.emptyIndexes...)
+	a.eachMatch(textBytes, func(patternIndex, end int) bool {
+		indexes = append(indexes, patternIndex)
+		return true
+	})
+	sort.Ints(indexes)
+	n := 0
+	for i, index := range indexes {
+		if i == 0 || indexes[n-1] != index {
+			indexes[n] = index
+			n++
+		}
+	}
+	return indexes[:n]
+}
+
+// MatchSpans returns non-overlapping occurrences for each pattern like
+// bytesMatcher does.
+func (a *ahoCorasick) MatchSpans(textBytes []byte) []MatchSpan {
+	var spans []MatchSpan
+	if a.hasEmpty {
+		for i, length := range a.patternLengths {
+			if length == 0 {
+				spans = append(spans, MatchSpan{Start: 0, End: 0, PatternIndex: i})
+			}
+		}
+	}
+
+	lastEnds := make(map[int]int)
+	a.eachMatch(textBytes, func(patternIndex, end int) bool {
+		start := end - a.patternLengths[patternIndex]
+		if lastEnd, ok := lastEnds[patternIndex]; !ok || lastEnd <= start {
+			spans = append(spans, MatchSpan{Start: start, End: end, PatternIndex: patternIndex})
+			lastEnds[patternIndex] = end
+		}
+		return true
+	})
+	sort.Stable(matchSpans(spans))
+	return spans
+}
@@ -0,0 +1,116 @@
+package book
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func bytesPatternsForTest(patterns ...string) [][]byte {
+	bytesPatterns := make([][]byte, len(patterns))
+	for i, p := range patterns {
+		bytesPatterns[i] = []byte(p)
+	}
+	return bytesPatterns
+}
+
+func TestAhoCorasickMatch(t *testing.T) {
+	a := newAhoCorasick(bytesPatternsForTest("he", "she", "his", "hers"))
+	if !a.Match([]byte("ushers")) || !a.Match([]byte("this")) || !a.Match([]byte("he")) {
+		t.Error("Match for Aho-Corasick doesn't work.")
+	}
+	if a.Match([]byte("")) || a.Match([]byte("h")) || a.Match([]byte("HE")) || a.Match([]byte("hi s")) {
+		t.Error("Match for Aho-Corasick shouldn't match.")
+	}
+}
+
+func TestAhoCorasickMatchEmptyPattern(t *testing.T) {
+	a := newAhoCorasick(bytesPatternsForTest("foo", ""))
+	if !a.Match([]byte("bar")) {
+		t.Error("Empty pattern should match any text.")
+	}
+}
+
+func TestAhoCorasickMatchSpans(t *testing.T) {
+	a := newAhoCorasick(bytesPatternsForTest("he", "she", "his", "hers", "aa"))
+	spans := a.MatchSpans([]byte("ushers aaa"))
+	expected := []MatchSpan{{1, 4, 1}, {2, 4, 0}, {2, 6, 3}, {7, 9, 4}}
+	if len(spans) != len(expected) {
+		t.Fatal("MatchSpans returns a wrong number of spans.", spans)
+	}
+	for i, span := range spans {
+		if span != expected[i] {
+			t.Error("MatchSpans returns a wrong span.", span, expected[i])
+		}
+	}
+}
+
+func TestAhoCorasickSameAsBytesMatcher(t *testing.T) {
+	patterns := make([]string, 0, 100)
+	for i := 0; i < 100; i++ {
+		patterns = append(patterns, fmt.Sprintf("k%dx", i*7))
+	}
+	m := newBytesMatcher(patterns, false)
+	if m.automaton == nil {
+		t.Fatal("Aho-Corasick should be used for many patterns.")
+	}
+	naive := newBytesMatcher(patterns[:ahoCorasickPatternThreshold-1], false)
+	if naive.automaton != nil {
+		t.Fatal("Aho-Corasick shouldn't be used for a few patterns.")
+	}
+
+	for i := 0; i < 200; i++ {
+		text := []byte(fmt.Sprintf("a k%dx k%dxk%dx b", i, i*3, i*5))
+		found := false
+		for _, p := range patterns {
+			found = found || bytes.Contains(text, []byte(p))
+		}
+		if m.Match(text) != found {
+			t.Error("Match for Aho-Corasick returns a different result.", string(text))
+		}
+	}
+}
+
+func TestAhoCorasickIgnoreCase(t *testing.T) {
+	patterns := make([]string, ahoCorasickPatternThreshold)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("k%dss", i)
+	}
+	m := newBytesMatcher(patterns, true)
+	if m.foldAutomaton == nil || m.automaton != nil {
+		t.Fatal("Aho-Corasick should be used for patterns ignoring case.")
+	}
+	if !m.Match([]byte("x \u212A1Sſ")) || m.Match([]byte("k1s")) {
+		t.Error("Patterns ignoring case should match Unicode case folding.")
+	}
+	spans := m.MatchSpans([]byte("K3SS \u212A3ss"))
+	if len(spans) != 2 || spans[0] != (MatchSpan{0, 4, 3}) || spans[1] != (MatchSpan{5, 11, 3}) {
+		t.Error("MatchSpans should return ranges in the text.", spans)
+	}
+
+	naive := newBytesMatcher(patterns, true)
+	naive.foldAutomaton = nil
+	for i := 0; i < 200; i++ {
+		text := []byte(fmt.Sprintf("a K%dSs k%dsSk%dſS b", i, i*3, i*5))
+		if m.Match(text) != naive.Match(text) {
+			t.Error("Match for Aho-Corasick returns a different result.", string(text))
+		}
+	}
+	if newBytesMatcher(patterns[:ahoCorasickPatternThreshold-1], true).foldAutomaton != nil {
+		t.Error("Aho-Corasick shouldn't be used for a few patterns.")
+	}
+}
+
+func BenchmarkBytesMatcherIgnoreCase(b *testing.B) {
+	patterns := make([]string, 1000)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("Blocked%dHost", i)
+	}
+	m := newBytesMatcher(patterns, true)
+	text := []byte(strings.Repeat("GET /index.html HTTP/1.1 from allowed host ", 4))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match(text)
+	}
+}
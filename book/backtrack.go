@@ -0,0 +1,1243 @@
+package book
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Default number of backtracks a pattern can take for a line.
+	backtrackMaxBacktracks = 1000000
+	// Default number of nested repetitions. Each of them uses the stack.
+	backtrackMaxDepth = 100000
+)
+
+// ErrBacktrackLimit is the error of a line which a Perl compatible pattern
+// can't decide to match within the limits of backtracking.
+var ErrBacktrackLimit = errors.New("exceeded the backtracking limit")
+
+// backtrackMatcher matches Perl compatible patterns by backtracking. It
+// supports lookahead, lookbehind, backreferences, atomic groups, lazy and
+// possessive quantifiers, and inline flags (?i), (?m) and (?s). Matching a
+// line stops after maxBacktracks backtracks or maxDepth nested repetitions,
+// so a pathological pattern can't hang a search. ErrBacktrackLimit is set
+// to lineError then.
+type backtrackMatcher struct {
+	matcher
+	regexps       []*backtrackRegexp
+	maxBacktracks int
+	maxDepth      int
+	lineError     *lineError
+	// presetChecks confirm matches of built-in presets.
+	presetChecks []presetCheck
+}
+
+// lineError is an error of matching the current line. Matchers of a page
+// share it.
+type lineError struct {
+	err error
+}
+
+type backtrackRegexp struct {
+	root       btNode
+	groupCount int
+	// nonEmpty rejects empty matches. It is set for WordRegexp.
+	nonEmpty bool
+}
+
+// backtrackState is the state of matching a line.
+type backtrackState struct {
+	text          []byte
+	captures      []int
+	backtracks    int
+	maxBacktracks int
+	depth         int
+	maxDepth      int
+	// tooDeep is set when depth reaches maxDepth.
+	tooDeep bool
+}
+
+type btNode interface {
+	// match tries to match the node at pos and calls k with the end
+	// position of each way to match until k returns true.
+	match(s *backtrackState, pos int, k func(int) bool) bool
+	// width returns the minimum and the maximum number of runes the node
+	// matches. The maximum is -1 if it is unbounded.
+	width() (int, int)
+}
+
+type btLiteral struct {
+	r    rune
+	fold bool
+}
+
+type btAny struct {
+	dotAll bool
+}
+
+type btClass struct {
+	// Pairs of the lowest and the highest rune.
+	ranges  []rune
+	classes []func(rune) bool
+	negate  bool
+	fold    bool
+}
+
+type btSequence struct {
+	nodes []btNode
+}
+
+type btAlternation struct {
+	alternatives []btNode
+}
+
+type btRepeat struct {
+	sub        btNode
+	min        int
+	max        int
+	lazy       bool
+	possessive bool
+}
+
+type btGroup struct {
+	sub   btNode
+	index int
+}
+
+type btAtomic struct {
+	sub btNode
+}
+
+type btLookaround struct {
+	sub    btNode
+	behind bool
+	negate bool
+}
+
+type btAssertKind int
+
+const (
+	btBeginLine btAssertKind = iota
+	btBeginLineMultiline
+	btEndLine
+	btEndLineMultiline
+	btBeginText
+	btEndText
+	btEndTextNewline
+	btWordBoundary
+	btNotWordBoundary
+	btNotAfterWord
+	btNotBeforeWord
+)
+
+type btAssert struct {
+	kind btAssertKind
+}
+
+type btBackreference struct {
+	index int
+	fold  bool
+}
+
+type btFlags struct {
+	ignoreCase bool
+	multiline  bool
+	dotAll     bool
+}
+
+type backtrackParser struct {
+	pattern    string
+	pos        int
+	groupCount int
+	groupNames map[string]int
+	// The largest group number used by backreferences.
+	maxReference int
+}
+
+var btPosixClasses = map[string]func(rune) bool{
+	"alnum":  func(r rune) bool { return isASCIIDigit(r) || isASCIILetter(r) },
+	"alpha":  isASCIILetter,
+	"ascii":  func(r rune) bool { return 0 <= r && r < utf8.RuneSelf },
+	"blank":  func(r rune) bool { return r == ' ' || r == '\t' },
+	"cntrl":  func(r rune) bool { return (0 <= r && r < ' ') || r == 0x7f },
+	"digit":  isASCIIDigit,
+	"graph":  func(r rune) bool { return '!' <= r && r <= '~' },
+	"lower":  func(r rune) bool { return 'a' <= r && r <= 'z' },
+	"print":  func(r rune) bool { return ' ' <= r && r <= '~' },
+	"punct":  func(r rune) bool { return '!' <= r && r <= '~' && !isASCIIDigit(r) && !isASCIILetter(r) },
+	"space":  isASCIISpace,
+	"upper":  func(r rune) bool { return 'A' <= r && r <= 'Z' },
+	"word":   isASCIIWord,
+	"xdigit": func(r rune) bool { return isASCIIDigit(r) || ('a' <= r && r <= 'f') || ('A' <= r && r <= 'F') },
+}
+
+// ValidatePerlRegexp checks pattern can be used by -P.
+func ValidatePerlRegexp(pattern string) error {
+	_, err := compileBacktrackRegexp(pattern, false, false, false)
+	return err
+}
+
+func newBacktrackMatcher(params *MatcherParams) (m *backtrackMatcher) {
+	m = new(backtrackMatcher)
+	m.patterns = params.Patterns
+	m.ignoreCase = params.IgnoreCase
+	m.ignoreCases = params.IgnoreCases
+	m.wordRegexp = params.WordRegexp
+	m.lineRegexp = params.LineRegexp
+	m.maxBacktracks = backtrackMaxBacktracks
+	m.maxDepth = backtrackMaxDepth
+	m.lineError = params.lineError
+	m.presetChecks = presetChecksOf(m.patterns)
+
+	m.regexps = make([]*backtrackRegexp, len(m.patterns))
+	for i, p := range m.patterns {
+		m.regexps[i], _ = compileBacktrackRegexp(p, m.isIgnoreCase(i), m.wordRegexp, m.lineRegexp)
+	}
+	return
+}
+
+func (m *backtrackMatcher) Match(textBytes []byte) bool {
+	for i, re := range m.regexps {
+		if re == nil {
+			continue
+		}
+		s := m.newState(re, textBytes)
+		for from := 0; from <= len(textBytes); {
+			start, end, err := s.find(re, from)
+			if start < 0 {
+				m.setLineError(err)
+				break
+			}
+			if acceptsPresetMatch(m.presetChecks, i, textBytes, start, end) {
+				return true
+			}
+			from = nextRuneOffset(textBytes, start)
+		}
+	}
+	return false
+}
+
+func (m *backtrackMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	var spans []MatchSpan
+	for i, re := range m.regexps {
+		if re == nil {
+			continue
+		}
+		s := m.newState(re, textBytes)
+		for from := 0; from <= len(textBytes); {
+			start, end, err := s.find(re, from)
+			if start < 0 {
+				m.setLineError(err)
+				break
+			}
+			if !acceptsPresetMatch(m.presetChecks, i, textBytes, start, end) {
+				from = nextRuneOffset(textBytes, start)
+				continue
+			}
+			spans = append(spans, MatchSpan{Start: start, End: end, PatternIndex: i})
+			if start < end {
+				from = end
+			} else {
+				from = nextRuneOffset(textBytes, end)
+			}
+		}
+	}
+	sort.Stable(matchSpans(spans))
+	return spans
+}
+
+// nextRuneOffset returns the offset of the character after offset. It is
+// beyond textBytes at the end of textBytes.
+func nextRuneOffset(textBytes []byte, offset int) int {
+	if offset >= len(textBytes) {
+		return offset + 1
+	}
+	_, size := utf8.DecodeRune(textBytes[offset:])
+	return offset + size
+}
+
+// compileBacktrackRegexp parses pattern. wordRegexp and lineRegexp wrap
+// the pattern by assertions, so the backtracking finds a match which
+// satisfies them.
+func compileBacktrackRegexp(pattern string, ignoreCase, wordRegexp, lineRegexp bool) (*backtrackRegexp, error) {
+	p := &backtrackParser{pattern: pattern, groupNames: map[string]int{}}
+	root, err := p.parseAlternation(btFlags{ignoreCase: ignoreCase})
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.pattern) {
+		return nil, errors.New("unmatched )")
+	}
+	if p.maxReference > p.groupCount {
+		return nil, fmt.Errorf("backreference to non-existent group %d", p.maxReference)
+	}
+
+	re := &backtrackRegexp{root: root, groupCount: p.groupCount}
+	if lineRegexp {
+		re.root = &btSequence{nodes: []btNode{&btAssert{kind: btBeginText}, root, &btAssert{kind: btEndText}}}
+	} else if wordRegexp {
+		re.root = &btSequence{nodes: []btNode{&btAssert{kind: btNotAfterWord}, root, &btAssert{kind: btNotBeforeWord}}}
+		re.nonEmpty = true
+	}
+	return re, nil
+}
+
+func (m *backtrackMatcher) newState(re *backtrackRegexp, textBytes []byte) *backtrackState {
+	return &backtrackState{
+		text:          textBytes,
+		captures:      make([]int, 2*(re.groupCount+1)),
+		maxBacktracks: m.maxBacktracks,
+		maxDepth:      m.maxDepth,
+	}
+}
+
+func (m *backtrackMatcher) setLineError(err error) {
+	if err != nil && m.lineError != nil {
+		m.lineError.err = err
+	}
+}
+
+// find returns the leftmost match which starts at or after from. It returns
+// -1, -1 if nothing matches, and ErrBacktrackLimit too if the limits are
+// exceeded.
+func (s *backtrackState) find(re *backtrackRegexp, from int) (int, int, error) {
+	for start := from; start <= len(s.text); {
+		for i := range s.captures {
+			s.captures[i] = -1
+		}
+		end := -1
+		matched := re.root.match(s, start, func(e int) bool {
+			if s.exceeded() || (re.nonEmpty && e == start) {
+				return false
+			}
+			end = e
+			return true
+		})
+		if matched {
+			return start, end, nil
+		}
+		if s.exceeded() {
+			return -1, -1, ErrBacktrackLimit
+		}
+		if start == len(s.text) {
+			break
+		}
+		_, size := utf8.DecodeRune(s.text[start:])
+		start += size
+	}
+	return -1, -1, nil
+}
+
+// backtrack counts a retry of another way to match and returns false if
+// the limit is exceeded.
+func (s *backtrackState) backtrack() bool {
+	s.backtracks++
+	return !s.exceeded()
+}
+
+func (s *backtrackState) exceeded() bool {
+	return s.backtracks > s.maxBacktracks || s.tooDeep
+}
+
+func (n *btLiteral) match(s *backtrackState, pos int, k func(int) bool) bool {
+	if s.exceeded() || pos >= len(s.text) {
+		return false
+	}
+	r, size := utf8.DecodeRune(s.text[pos:])
+	if r != n.r && !(n.fold && foldRune(r) == foldRune(n.r)) {
+		return false
+	}
+	return k(pos + size)
+}
+
+func (n *btLiteral) width() (int, int) {
+	return 1, 1
+}
+
+func (n *btAny) match(s *backtrackState, pos int, k func(int) bool) bool {
+	if s.exceeded() || pos >= len(s.text) {
+		return false
+	}
+	r, size := utf8.DecodeRune(s.text[pos:])
+	if r == '\n' && !n.dotAll {
+		return false
+	}
+	return k(pos + size)
+}
+
+func (n *btAny) width() (int, int) {
+	return 1, 1
+}
+
+func (n *btClass) match(s *backtrackState, pos int, k func(int) bool) bool {
+	if s.exceeded() || pos >= len(s.text) {
+		return false
+	}
+	r, size := utf8.DecodeRune(s.text[pos:])
+	if !n.matchRune(r) {
+		return false
+	}
+	return k(pos + size)
+}
+
+func (n *btClass) matchRune(r rune) bool {
+	matched := n.contains(r)
+	if !matched && n.fold {
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if n.contains(f) {
+				matched = true
+				break
+			}
+		}
+	}
+	return matched != n.negate
+}
+
+func (n *btClass) contains(r rune) bool {
+	for i := 0; i < len(n.ranges); i += 2 {
+		if n.ranges[i] <= r && r <= n.ranges[i+1] {
+			return true
+		}
+	}
+	for _, class := range n.classes {
+		if class(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *btClass) width() (int, int) {
+	return 1, 1
+}
+
+func (n *btSequence) match(s *backtrackState, pos int, k func(int) bool) bool {
+	return n.matchFrom(s, 0, pos, k)
+}
+
+func (n *btSequence) matchFrom(s *backtrackState, i int, pos int, k func(int) bool) bool {
+	if i == len(n.nodes) {
+		return k(pos)
+	}
+	return n.nodes[i].match(s, pos, func(e int) bool {
+		return n.matchFrom(s, i+1, e, k)
+	})
+}
+
+func (n *btSequence) width() (int, int) {
+	min, max := 0, 0
+	for _, node := range n.nodes {
+		nodeMin, nodeMax := node.width()
+		min += nodeMin
+		if max >= 0 && nodeMax >= 0 {
+			max += nodeMax
+		} else {
+			max = -1
+		}
+	}
+	return min, max
+}
+
+func (n *btAlternation) match(s *backtrackState, pos int, k func(int) bool) bool {
+	for i, alternative := range n.alternatives {
+		if s.exceeded() || (i > 0 && !s.backtrack()) {
+			return false
+		}
+		if alternative.match(s, pos, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *btAlternation) width() (int, int) {
+	min, max := -1, 0
+	for _, alternative := range n.alternatives {
+		altMin, altMax := alternative.width()
+		if min < 0 || altMin < min {
+			min = altMin
+		}
+		if max >= 0 && (altMax < 0 || altMax > max) {
+			max = altMax
+		}
+	}
+	return min, max
+}
+
+func (n *btRepeat) match(s *backtrackState, pos int, k func(int) bool) bool {
+	switch n.sub.(type) {
+	case *btLiteral, *btAny, *btClass:
+		return n.matchRunes(s, pos, k)
+	}
+	if n.possessive {
+		end := -1
+		if !n.matchCount(s, pos, 0, func(e int) bool { end = e; return true }) {
+			return false
+		}
+		return k(end)
+	}
+	return n.matchCount(s, pos, 0, k)
+}
+
+// matchRunes matches repetitions of a node which matches a rune by a loop,
+// so long repetitions don't use the stack.
+func (n *btRepeat) matchRunes(s *backtrackState, pos int, k func(int) bool) bool {
+	next := func(pos int) int {
+		end := -1
+		n.sub.match(s, pos, func(e int) bool { end = e; return true })
+		return end
+	}
+	if n.lazy {
+		for count := 0; ; count++ {
+			if count >= n.min {
+				if count > n.min && !s.backtrack() {
+					return false
+				}
+				if k(pos) {
+					return true
+				}
+			}
+			if n.max >= 0 && count >= n.max {
+				return false
+			}
+			if pos = next(pos); pos < 0 {
+				return false
+			}
+		}
+	}
+
+	// ends[i] is the end of i repetitions.
+	ends := []int{pos}
+	for n.max < 0 || len(ends) <= n.max {
+		end := next(ends[len(ends)-1])
+		if end < 0 {
+			break
+		}
+		ends = append(ends, end)
+	}
+	if n.possessive {
+		return len(ends) > n.min && k(ends[len(ends)-1])
+	}
+	for i := len(ends) - 1; i >= n.min; i-- {
+		if i < len(ends)-1 && !s.backtrack() {
+			return false
+		}
+		if k(ends[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCount matches the rest of repetitions after count repetitions.
+// A repetition which matches an empty string ends the loop.
+func (n *btRepeat) matchCount(s *backtrackState, pos int, count int, k func(int) bool) bool {
+	if s.depth >= s.maxDepth {
+		s.tooDeep = true
+	}
+	if s.exceeded() {
+		return false
+	}
+	s.depth++
+	defer func() {
+		s.depth--
+	}()
+	more := func() bool {
+		if n.max >= 0 && count >= n.max {
+			return false
+		}
+		return n.sub.match(s, pos, func(e int) bool {
+			if e == pos && count >= n.min {
+				return false
+			}
+			return n.matchCount(s, e, count+1, k)
+		})
+	}
+	if count < n.min {
+		return more()
+	}
+	if n.lazy {
+		return k(pos) || (s.backtrack() && more())
+	}
+	return more() || (s.backtrack() && k(pos))
+}
+
+func (n *btRepeat) width() (int, int) {
+	subMin, subMax := n.sub.width()
+	max := -1
+	if subMax == 0 {
+		max = 0
+	} else if n.max >= 0 && subMax >= 0 {
+		max = n.max * subMax
+	}
+	return n.min * subMin, max
+}
+
+func (n *btGroup) match(s *backtrackState, pos int, k func(int) bool) bool {
+	return n.sub.match(s, pos, func(e int) bool {
+		oldStart, oldEnd := s.captures[2*n.index], s.captures[2*n.index+1]
+		s.captures[2*n.index], s.captures[2*n.index+1] = pos, e
+		if k(e) {
+			return true
+		}
+		s.captures[2*n.index], s.captures[2*n.index+1] = oldStart, oldEnd
+		return false
+	})
+}
+
+func (n *btGroup) width() (int, int) {
+	return n.sub.width()
+}
+
+func (n *btAtomic) match(s *backtrackState, pos int, k func(int) bool) bool {
+	end := -1
+	if !n.sub.match(s, pos, func(e int) bool { end = e; return true }) {
+		return false
+	}
+	return k(end)
+}
+
+func (n *btAtomic) width() (int, int) {
+	return n.sub.width()
+}
+
+func (n *btLookaround) match(s *backtrackState, pos int, k func(int) bool) bool {
+	if s.exceeded() {
+		return false
+	}
+	found := false
+	if n.behind {
+		min, max := n.sub.width()
+		start, runes := pos, 0
+		for !found && (max < 0 || runes <= max) {
+			if runes >= min {
+				found = n.sub.match(s, start, func(e int) bool { return e == pos })
+			}
+			if found || start == 0 || !s.backtrack() {
+				break
+			}
+			_, size := utf8.DecodeLastRune(s.text[:start])
+			start -= size
+			runes++
+		}
+	} else {
+		found = n.sub.match(s, pos, func(int) bool { return true })
+	}
+	if found == n.negate || s.exceeded() {
+		return false
+	}
+	return k(pos)
+}
+
+func (n *btLookaround) width() (int, int) {
+	return 0, 0
+}
+
+func (n *btAssert) match(s *backtrackState, pos int, k func(int) bool) bool {
+	if s.exceeded() {
+		return false
+	}
+	text := s.text
+	matched := false
+	switch n.kind {
+	case btBeginLine, btBeginText:
+		matched = pos == 0
+	case btBeginLineMultiline:
+		matched = pos == 0 || text[pos-1] == '\n'
+	case btEndLine, btEndTextNewline:
+		matched = pos == len(text) || (pos == len(text)-1 && text[pos] == '\n')
+	case btEndLineMultiline:
+		matched = pos == len(text) || text[pos] == '\n'
+	case btEndText:
+		matched = pos == len(text)
+	case btWordBoundary, btNotWordBoundary:
+		before := pos > 0 && isASCIIWord(rune(text[pos-1]))
+		after := pos < len(text) && isASCIIWord(rune(text[pos]))
+		matched = (before != after) == (n.kind == btWordBoundary)
+	case btNotAfterWord:
+		r, _ := utf8.DecodeLastRune(text[:pos])
+		matched = pos == 0 || !isWordRune(r)
+	case btNotBeforeWord:
+		r, _ := utf8.DecodeRune(text[pos:])
+		matched = pos == len(text) || !isWordRune(r)
+	}
+	return matched && k(pos)
+}
+
+func (n *btAssert) width() (int, int) {
+	return 0, 0
+}
+
+func (n *btBackreference) match(s *backtrackState, pos int, k func(int) bool) bool {
+	if s.exceeded() {
+		return false
+	}
+	start, end := s.captures[2*n.index], s.captures[2*n.index+1]
+	if start < 0 {
+		return false
+	}
+	group := s.text[start:end]
+	if !n.fold {
+		if !bytes.HasPrefix(s.text[pos:], group) {
+			return false
+		}
+		return k(pos + len(group))
+	}
+
+	i, j := 0, pos
+	for i < len(group) {
+		if j >= len(s.text) {
+			return false
+		}
+		r1, size1 := decodeFoldRune(group[i:])
+		r2, size2 := decodeFoldRune(s.text[j:])
+		if r1 != r2 {
+			return false
+		}
+		i += size1
+		j += size2
+	}
+	return k(j)
+}
+
+func (n *btBackreference) width() (int, int) {
+	return 0, -1
+}
+
+func (p *backtrackParser) peek() (rune, int) {
+	if p.pos >= len(p.pattern) {
+		return -1, 0
+	}
+	return utf8.DecodeRuneInString(p.pattern[p.pos:])
+}
+
+func (p *backtrackParser) next() rune {
+	r, size := p.peek()
+	p.pos += size
+	return r
+}
+
+func (p *backtrackParser) consume(prefix string) bool {
+	if strings.HasPrefix(p.pattern[p.pos:], prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// parseAlternation parses alternatives until ')' or the end. Inline flags
+// change flags for the rest of the group.
+func (p *backtrackParser) parseAlternation(flags btFlags) (btNode, error) {
+	var alternatives []btNode
+	for {
+		sequence, err := p.parseSequence(&flags)
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, sequence)
+		if !p.consume("|") {
+			break
+		}
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return &btAlternation{alternatives: alternatives}, nil
+}
+
+func (p *backtrackParser) parseSequence(flags *btFlags) (btNode, error) {
+	sequence := &btSequence{}
+	for p.pos < len(p.pattern) {
+		r, _ := p.peek()
+		if r == '|' || r == ')' {
+			break
+		}
+		atom, err := p.parseAtom(flags)
+		if err != nil {
+			return nil, err
+		}
+		if atom == nil {
+			continue
+		}
+		atom, err = p.parseQuantifier(atom)
+		if err != nil {
+			return nil, err
+		}
+		sequence.nodes = append(sequence.nodes, atom)
+	}
+	return sequence, nil
+}
+
+func (p *backtrackParser) parseAtom(flags *btFlags) (btNode, error) {
+	r := p.next()
+	switch r {
+	case '(':
+		return p.parseGroup(flags)
+	case '[':
+		return p.parseClass(flags)
+	case '.':
+		return &btAny{dotAll: flags.dotAll}, nil
+	case '^':
+		if flags.multiline {
+			return &btAssert{kind: btBeginLineMultiline}, nil
+		}
+		return &btAssert{kind: btBeginLine}, nil
+	case '$':
+		if flags.multiline {
+			return &btAssert{kind: btEndLineMultiline}, nil
+		}
+		return &btAssert{kind: btEndLine}, nil
+	case '\\':
+		return p.parseEscape(flags)
+	case '*', '+', '?':
+		return nil, fmt.Errorf("missing argument to repetition operator %c", r)
+	}
+	return &btLiteral{r: r, fold: flags.ignoreCase}, nil
+}
+
+func (p *backtrackParser) parseQuantifier(atom btNode) (btNode, error) {
+	repeat := &btRepeat{sub: atom}
+	switch r, _ := p.peek(); r {
+	case '*':
+		repeat.min, repeat.max = 0, -1
+	case '+':
+		repeat.min, repeat.max = 1, -1
+	case '?':
+		repeat.min, repeat.max = 0, 1
+	case '{':
+		min, max, size, ok := parseBacktrackInterval(p.pattern[p.pos:])
+		if !ok {
+			return atom, nil
+		}
+		if max >= 0 && max < min {
+			return nil, fmt.Errorf("invalid repetition %s", p.pattern[p.pos:p.pos+size])
+		}
+		repeat.min, repeat.max = min, max
+		p.pos += size - 1
+	default:
+		return atom, nil
+	}
+	p.pos++
+
+	if p.consume("?") {
+		repeat.lazy = true
+	} else if p.consume("+") {
+		repeat.possessive = true
+	}
+	if r, _ := p.peek(); r == '*' || r == '+' || r == '?' {
+		return nil, fmt.Errorf("invalid nested repetition operator %c", r)
+	}
+	return repeat, nil
+}
+
+// parseBacktrackInterval parses {n}, {n,} or {n,m} at the beginning of text.
+// It returns the size of the interval and false if it isn't an interval.
+func parseBacktrackInterval(text string) (int, int, int, bool) {
+	end := strings.IndexByte(text, '}')
+	if end < 0 {
+		return 0, 0, 0, false
+	}
+	parts := strings.Split(text[1:end], ",")
+	if len(parts) > 2 {
+		return 0, 0, 0, false
+	}
+	min, err := strconv.Atoi(parts[0])
+	if err != nil || min < 0 {
+		return 0, 0, 0, false
+	}
+	max := min
+	if len(parts) == 2 {
+		max = -1
+		if parts[1] != "" {
+			max, err = strconv.Atoi(parts[1])
+			if err != nil || max < 0 {
+				return 0, 0, 0, false
+			}
+		}
+	}
+	return min, max, end + 1, true
+}
+
+func (p *backtrackParser) parseGroup(flags *btFlags) (btNode, error) {
+	var node btNode
+	var err error
+	groupFlags := *flags
+
+	switch {
+	case p.consume("?:"):
+		node, err = p.parseAlternation(groupFlags)
+	case p.consume("?="), p.consume("?!"):
+		negate := p.pattern[p.pos-1] == '!'
+		node, err = p.parseAlternation(groupFlags)
+		node = &btLookaround{sub: node, negate: negate}
+	case p.consume("?<="), p.consume("?<!"):
+		negate := p.pattern[p.pos-1] == '!'
+		node, err = p.parseAlternation(groupFlags)
+		node = &btLookaround{sub: node, behind: true, negate: negate}
+	case p.consume("?>"):
+		node, err = p.parseAlternation(groupFlags)
+		node = &btAtomic{sub: node}
+	case p.consume("?#"):
+		end := strings.IndexByte(p.pattern[p.pos:], ')')
+		if end < 0 {
+			return nil, errors.New("missing ) after comment")
+		}
+		p.pos += end + 1
+		return nil, nil
+	case p.consume("?<"), p.consume("?P<"), p.consume("?'"):
+		terminator := ">"
+		if p.pattern[p.pos-1] == '\'' {
+			terminator = "'"
+		}
+		end := strings.Index(p.pattern[p.pos:], terminator)
+		if end <= 0 {
+			return nil, errors.New("invalid group name")
+		}
+		name := p.pattern[p.pos : p.pos+end]
+		p.pos += end + 1
+		if _, ok := p.groupNames[name]; ok {
+			return nil, fmt.Errorf("duplicate group name %s", name)
+		}
+		p.groupCount++
+		p.groupNames[name] = p.groupCount
+		node, err = p.parseCapture(groupFlags)
+	case p.consume("?"):
+		return p.parseFlags(flags)
+	default:
+		p.groupCount++
+		node, err = p.parseCapture(groupFlags)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !p.consume(")") {
+		return nil, errors.New("missing )")
+	}
+	return node, nil
+}
+
+func (p *backtrackParser) parseCapture(flags btFlags) (btNode, error) {
+	index := p.groupCount
+	sub, err := p.parseAlternation(flags)
+	if err != nil {
+		return nil, err
+	}
+	return &btGroup{sub: sub, index: index}, nil
+}
+
+// parseFlags parses (?flags) which changes flags for the rest of the group
+// and (?flags:...) which is a group with flags.
+func (p *backtrackParser) parseFlags(flags *btFlags) (btNode, error) {
+	newFlags := *flags
+	enable := true
+	for {
+		r := p.next()
+		switch r {
+		case 'i':
+			newFlags.ignoreCase = enable
+		case 'm':
+			newFlags.multiline = enable
+		case 's':
+			newFlags.dotAll = enable
+		case '-':
+			enable = false
+		case ')':
+			*flags = newFlags
+			return nil, nil
+		case ':':
+			node, err := p.parseAlternation(newFlags)
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume(")") {
+				return nil, errors.New("missing )")
+			}
+			return node, nil
+		case -1:
+			return nil, errors.New("missing )")
+		default:
+			return nil, fmt.Errorf("unknown flag %c", r)
+		}
+	}
+}
+
+func (p *backtrackParser) parseEscape(flags *btFlags) (btNode, error) {
+	r, _ := p.peek()
+	switch {
+	case r == -1:
+		return nil, errors.New("trailing backslash (\\)")
+	case r == 'b':
+		p.next()
+		return &btAssert{kind: btWordBoundary}, nil
+	case r == 'B':
+		p.next()
+		return &btAssert{kind: btNotWordBoundary}, nil
+	case r == 'A':
+		p.next()
+		return &btAssert{kind: btBeginText}, nil
+	case r == 'z':
+		p.next()
+		return &btAssert{kind: btEndText}, nil
+	case r == 'Z':
+		p.next()
+		return &btAssert{kind: btEndTextNewline}, nil
+	case '1' <= r && r <= '9':
+		start := p.pos
+		for r, _ := p.peek(); '0' <= r && r <= '9'; r, _ = p.peek() {
+			p.next()
+		}
+		index, _ := strconv.Atoi(p.pattern[start:p.pos])
+		if index > p.maxReference {
+			p.maxReference = index
+		}
+		return &btBackreference{index: index, fold: flags.ignoreCase}, nil
+	case r == 'k':
+		p.next()
+		terminator := ""
+		switch p.next() {
+		case '<':
+			terminator = ">"
+		case '{':
+			terminator = "}"
+		case '\'':
+			terminator = "'"
+		default:
+			return nil, errors.New("invalid \\k reference")
+		}
+		end := strings.Index(p.pattern[p.pos:], terminator)
+		if end <= 0 {
+			return nil, errors.New("invalid \\k reference")
+		}
+		name := p.pattern[p.pos : p.pos+end]
+		p.pos += end + 1
+		index, ok := p.groupNames[name]
+		if !ok {
+			return nil, fmt.Errorf("reference to non-existent group %s", name)
+		}
+		return &btBackreference{index: index, fold: flags.ignoreCase}, nil
+	case r == 'Q':
+		p.next()
+		end := strings.Index(p.pattern[p.pos:], "\\E")
+		if end < 0 {
+			end = len(p.pattern) - p.pos
+		}
+		sequence := &btSequence{}
+		for _, r := range p.pattern[p.pos : p.pos+end] {
+			sequence.nodes = append(sequence.nodes, &btLiteral{r: r, fold: flags.ignoreCase})
+		}
+		p.pos += end
+		p.consume("\\E")
+		return sequence, nil
+	case r == 'E':
+		p.next()
+		return nil, nil
+	}
+
+	class, err := p.parseClassEscape()
+	if err != nil || class != nil {
+		return class, err
+	}
+	lit, err := p.parseRuneEscape()
+	if err != nil {
+		return nil, err
+	}
+	return &btLiteral{r: lit, fold: flags.ignoreCase}, nil
+}
+
+// parseClassEscape parses \d, \w, \s, \p{...} and their negations. It
+// returns nil if the escape isn't a class.
+func (p *backtrackParser) parseClassEscape() (*btClass, error) {
+	r, _ := p.peek()
+	var class func(rune) bool
+	switch unicode.ToLower(r) {
+	case 'd':
+		class = isASCIIDigit
+	case 'w':
+		class = isASCIIWord
+	case 's':
+		class = isASCIISpace
+	case 'p':
+		p.next()
+		name := ""
+		if p.consume("{") {
+			end := strings.IndexByte(p.pattern[p.pos:], '}')
+			if end < 0 {
+				return nil, errors.New("missing } after \\p")
+			}
+			name = p.pattern[p.pos : p.pos+end]
+			p.pos += end + 1
+		} else if n, _ := p.peek(); n != -1 {
+			name = string(p.next())
+		}
+		negate := r == 'P'
+		if strings.HasPrefix(name, "^") {
+			name, negate = name[1:], !negate
+		}
+		table := unicode.Categories[name]
+		if table == nil {
+			table = unicode.Scripts[name]
+		}
+		if table == nil {
+			return nil, fmt.Errorf("unknown property \\p{%s}", name)
+		}
+		return &btClass{classes: []func(rune) bool{func(r rune) bool { return unicode.Is(table, r) }}, negate: negate}, nil
+	default:
+		return nil, nil
+	}
+	p.next()
+	return &btClass{classes: []func(rune) bool{class}, negate: unicode.IsUpper(r)}, nil
+}
+
+// parseRuneEscape parses an escape which is a rune, e.g. \n, \x41 and \*.
+func (p *backtrackParser) parseRuneEscape() (rune, error) {
+	r := p.next()
+	switch r {
+	case 't':
+		return '\t', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 'f':
+		return '\f', nil
+	case 'v':
+		return '\v', nil
+	case 'a':
+		return '\a', nil
+	case 'e':
+		return 0x1b, nil
+	case '0':
+		end := p.pos
+		for end < len(p.pattern) && end < p.pos+2 && '0' <= p.pattern[end] && p.pattern[end] <= '7' {
+			end++
+		}
+		value, _ := strconv.ParseInt("0"+p.pattern[p.pos:end], 8, 32)
+		p.pos = end
+		return rune(value), nil
+	case 'x':
+		var digits string
+		if p.consume("{") {
+			end := strings.IndexByte(p.pattern[p.pos:], '}')
+			if end < 0 {
+				return 0, errors.New("missing } after \\x")
+			}
+			digits = p.pattern[p.pos : p.pos+end]
+			p.pos += end + 1
+		} else {
+			end := p.pos
+			for end < len(p.pattern) && end < p.pos+2 && isHexDigit(p.pattern[end]) {
+				end++
+			}
+			digits = p.pattern[p.pos:end]
+			p.pos = end
+		}
+		value, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || value > unicode.MaxRune {
+			return 0, fmt.Errorf("invalid escape \\x%s", digits)
+		}
+		return rune(value), nil
+	}
+	if r < utf8.RuneSelf && (isASCIILetter(r) || isASCIIDigit(r)) {
+		return 0, fmt.Errorf("unknown escape \\%c", r)
+	}
+	return r, nil
+}
+
+// parseClass parses a character class after '['.
+func (p *backtrackParser) parseClass(flags *btFlags) (btNode, error) {
+	class := &btClass{fold: flags.ignoreCase}
+	class.negate = p.consume("^")
+
+	for first := true; ; first = false {
+		r := p.next()
+		switch {
+		case r == -1:
+			return nil, errors.New("missing ]")
+		case r == ']' && !first:
+			return class, nil
+		case r == '[' && strings.HasPrefix(p.pattern[p.pos:], ":"):
+			end := strings.Index(p.pattern[p.pos:], ":]")
+			if end < 0 {
+				return nil, errors.New("missing :]")
+			}
+			name := p.pattern[p.pos+1 : p.pos+end]
+			p.pos += end + 2
+			negate := strings.HasPrefix(name, "^")
+			posixClass := btPosixClasses[strings.TrimPrefix(name, "^")]
+			if posixClass == nil {
+				return nil, fmt.Errorf("unknown POSIX class [:%s:]", name)
+			}
+			if negate {
+				class.classes = append(class.classes, func(r rune) bool { return !posixClass(r) })
+			} else {
+				class.classes = append(class.classes, posixClass)
+			}
+			continue
+		}
+
+		lo := r
+		if r == '\\' {
+			escapeClass, err := p.parseClassEscape()
+			if err != nil {
+				return nil, err
+			}
+			if escapeClass != nil {
+				class.classes = append(class.classes, escapeClass.matchRune)
+				continue
+			}
+			if lo, err = p.parseClassRune(); err != nil {
+				return nil, err
+			}
+		}
+
+		hi := lo
+		if strings.HasPrefix(p.pattern[p.pos:], "-") && !strings.HasPrefix(p.pattern[p.pos:], "-]") && p.pos+1 < len(p.pattern) {
+			p.next()
+			var err error
+			if hi = p.next(); hi == '\\' {
+				if hi, err = p.parseClassRune(); err != nil {
+					return nil, err
+				}
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("invalid character class range %c-%c", lo, hi)
+			}
+		}
+		class.ranges = append(class.ranges, lo, hi)
+	}
+}
+
+// parseClassRune parses a rune escape in a character class, where \b is a
+// backspace.
+func (p *backtrackParser) parseClassRune() (rune, error) {
+	if p.consume("b") {
+		return '\b', nil
+	}
+	return p.parseRuneEscape()
+}
+
+func isASCIIDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+func isASCIILetter(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+func isASCIIWord(r rune) bool {
+	return isASCIIDigit(r) || isASCIILetter(r) || r == '_'
+}
+
+func isASCIISpace(r rune) bool {
+	return r == ' ' || ('\t' <= r && r <= '\r')
+}
+
+func isHexDigit(b byte) bool {
+	return ('0' <= b && b <= '9') || ('a' <= b && b <= 'f') || ('A' <= b && b <= 'F')
+}
@@ -0,0 +1,155 @@
+package book
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBacktrackMatcherMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		matched bool
+	}{
+		{`foo\d+`, "xfoo12", true},
+		{`foo\d+`, "foo", false},
+		{`^a.c$`, "abc", true},
+		{`colou?r`, "color", true},
+		{`(ab){2,}`, "xababx", true},
+		{`(ab){3}`, "xababx", false},
+		{`[^a-c]x`, "ax bx dx", true},
+		{`[[:digit:]_]+z`, "1_2z", true},
+		{`foo(?=bar)`, "foobar", true},
+		{`foo(?=bar)`, "foobaz", false},
+		{`foo(?!bar)`, "foobar", false},
+		{`foo(?!bar)`, "foobaz", true},
+		{`(?<=\$)\d+`, "cost $42", true},
+		{`(?<=\$)\d+`, "cost 42", false},
+		{`(?<!\$)\b\d+`, "cost $42", false},
+		{`(?<=ab|c)d`, "abd", true},
+		{`(\w+) \1`, "hello hello world", true},
+		{`(\w+) \1`, "hello world", false},
+		{`(?<word>o+)k\k<word>`, "ookoo", true},
+		{`(?i)HELLO`, "hello", true},
+		{`a(?i:B)c`, "abc", true},
+		{`a(?i:B)c`, "abC", false},
+		{`(?>a+)b`, "aaab", true},
+		{`(?>a+)ab`, "aaab", false},
+		{`a++b`, "aaab", true},
+		{`a++ab`, "aaab", false},
+		{`\Qa.b\E+`, "a.bb", true},
+		{`\Qa.b\E`, "axb", false},
+		{`\x41\x{3b1}`, "Aα", true},
+		{`\p{Greek}+`, "αβγ", true},
+		{`a{,2}`, "a{,2}", true},
+		{`.`, "", false},
+		{``, "", true},
+	}
+	for _, test := range tests {
+		m := NewMatcher(&MatcherParams{Patterns: []string{test.pattern}, PerlRegexp: true})
+		if m.Match([]byte(test.text)) != test.matched {
+			t.Error("Match returned an unexpected result.", test.pattern, test.text, test.matched)
+		}
+	}
+}
+
+func TestBacktrackMatcherMatchSpans(t *testing.T) {
+	m := NewMatcher(&MatcherParams{Patterns: []string{`a+?`, `(?<=c)d`}, PerlRegexp: true})
+	spans := m.MatchSpans([]byte("aa cd"))
+	if len(spans) != 3 || spans[0] != (MatchSpan{0, 1, 0}) || spans[1] != (MatchSpan{1, 2, 0}) ||
+		spans[2] != (MatchSpan{4, 5, 1}) {
+		t.Error("MatchSpans should return lazy and lookbehind matches.", spans)
+	}
+	spans = NewMatcher(&MatcherParams{Patterns: []string{`x*`}, PerlRegexp: true}).MatchSpans([]byte("ab"))
+	if len(spans) != 3 {
+		t.Error("Empty matches should advance by a rune.", spans)
+	}
+}
+
+func TestBacktrackMatcherIgnoreCase(t *testing.T) {
+	m := NewMatcher(&MatcherParams{Patterns: []string{`(ǆ\w) \1`, `[a-c]Z`}, IgnoreCase: true, PerlRegexp: true})
+	if !m.Match([]byte("Ǆa ǆA")) || m.Match([]byte("ǆa ǆb")) {
+		t.Error("Backreference should ignore case.")
+	}
+	if !m.Match([]byte("Bz")) {
+		t.Error("Character class should ignore case.")
+	}
+	m = NewMatcher(&MatcherParams{Patterns: []string{`Foo`}, IgnoreCases: []bool{false}, IgnoreCase: true, PerlRegexp: true})
+	if m.Match([]byte("foo")) {
+		t.Error("IgnoreCases should override IgnoreCase.")
+	}
+}
+
+func TestBacktrackMatcherWordAndLine(t *testing.T) {
+	m := NewMatcher(&MatcherParams{Patterns: []string{`fo+`}, WordRegexp: true, PerlRegexp: true})
+	if !m.Match([]byte("foox foo")) || m.Match([]byte("foox xfoo")) {
+		t.Error("WordRegexp should select whole words.")
+	}
+	spans := m.MatchSpans([]byte("foox foo"))
+	if len(spans) != 1 || spans[0].Start != 5 {
+		t.Error("WordRegexp should backtrack to find a whole word.", spans)
+	}
+	m = NewMatcher(&MatcherParams{Patterns: []string{`a|ab`}, LineRegexp: true, PerlRegexp: true})
+	if !m.Match([]byte("ab")) || m.Match([]byte("abc")) {
+		t.Error("LineRegexp should select the whole line.")
+	}
+}
+
+func TestBacktrackMatcherLimit(t *testing.T) {
+	lineError := new(lineError)
+	m := newBacktrackMatcher(&MatcherParams{Patterns: []string{`(a+)+$`}, PerlRegexp: true, lineError: lineError})
+	text := []byte(strings.Repeat("a", 40) + "b")
+	if m.Match(text) || lineError.err != ErrBacktrackLimit {
+		t.Error("Pathological pattern should stop by the backtracking limit.", lineError.err)
+	}
+	lineError.err = nil
+	if !m.Match([]byte("aaa")) || lineError.err != nil {
+		t.Error("Backtracking limit should be reset for each line.")
+	}
+
+	m = newBacktrackMatcher(&MatcherParams{Patterns: []string{`a*$`}, PerlRegexp: true, lineError: lineError})
+	text = []byte(strings.Repeat("a", 2*backtrackMaxBacktracks))
+	if spans := m.MatchSpans(text); len(spans) == 0 || spans[0].End != len(text) || lineError.err != nil {
+		t.Error("Repetitions without backtracks shouldn't exceed the limit.", spans, lineError.err)
+	}
+
+	m = newBacktrackMatcher(&MatcherParams{Patterns: []string{`^(ab)*$`}, PerlRegexp: true, lineError: lineError})
+	m.maxDepth = 100
+	lineError.err = nil
+	if !m.Match([]byte(strings.Repeat("ab", 99))) || lineError.err != nil {
+		t.Error("Nested repetitions should match within the depth limit.", lineError.err)
+	}
+	if m.Match([]byte(strings.Repeat("ab", 100))) || lineError.err != ErrBacktrackLimit {
+		t.Error("Nested repetitions should stop by the depth limit.", lineError.err)
+	}
+}
+
+func TestFindBacktrackLimit(t *testing.T) {
+	c := newChapterBytes([]byte("aaa\n" + strings.Repeat("a", 40) + "b\nb\n"))
+	defer c.Close()
+	var errorLineNumbers []LineNumber
+	count := c.Find(&FindParams{
+		Patterns:    []string{`^(a+)+$`},
+		PerlRegexp:  true,
+		InvertMatch: true,
+		ErrorHandler: func(params *FoundParams, err error) {
+			errorLineNumbers = append(errorLineNumbers, params.lineNumber)
+		},
+	})
+	if count != 1 || len(errorLineNumbers) != 1 || errorLineNumbers[0] != 2 {
+		t.Error("A line which exceeds the limit shouldn't be selected.", count, errorLineNumbers)
+	}
+}
+
+func TestValidatePerlRegexp(t *testing.T) {
+	for _, pattern := range []string{`(a`, `a)`, `*a`, `a**`, `[a`, `\2(a)`, `\k<x>`, `a{3,1}`, `(?z)`, `\q`, `[z-a]`, `\`} {
+		if ValidatePerlRegexp(pattern) == nil {
+			t.Error("ValidatePerlRegexp should return an error.", pattern)
+		}
+	}
+	for _, pattern := range []string{`(a)\1`, `(?#comment)a`, `a{2`, `[]a]`, `[\]\-]`, `(?P<n>a)(?'m'b)\k{n}`} {
+		if err := ValidatePerlRegexp(pattern); err != nil {
+			t.Error("ValidatePerlRegexp should accept a pattern.", pattern, err)
+		}
+	}
+}
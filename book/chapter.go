@@ -4,16 +4,26 @@ import (
 	"fmt"
 	"github.com/hata/goseq"
 	"io"
+	"io/ioutil"
+	"os"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 const (
 	lineNumberStartAt = LineNumber(1)
+	pageCapacity      = 1024 * 2
 )
 
 type PageIndex byte
 type FoundHandler func(params *FoundParams)
 
+// ErrorHandler is called with a line which can't be matched. The line isn't
+// selected.
+type ErrorHandler func(params *FoundParams, err error)
+
 type FoundCountType uint64
 
 type FindParams struct {
@@ -21,8 +31,29 @@ type FindParams struct {
 	AfterContextLength  int
 	BeforeContextLength int
 	IgnoreCase          bool
+	IgnoreCases         []bool
 	FixedStrings        bool
+	InvertMatch         bool
+	WordRegexp          bool
+	LineRegexp          bool
+	PatternLabels       []string
+	PrintPatternLabel   bool
+	Expression          *Expression
+	AllMatch            bool
+	Multiline           bool
+	MaxErrors           int
+	PerlRegexp          bool
+	LongestMatch        bool
+	MaxCount            int
+	StopOnFirstMatch    bool
+	TypedMatchers       []TypedMatcher
+	Fields              *FieldParams
+	JSON                *JSONParams
+	Logfmt              *LogfmtParams
+	TimeRange           *TimeRangeParams
+	HexPatterns         []*HexPattern
 	Handler             FoundHandler
+	ErrorHandler        ErrorHandler
 }
 
 type FoundParams struct {
@@ -31,6 +62,16 @@ type FoundParams struct {
 	lineNumber    LineNumber
 	page          Page
 	linePosInPage int
+	matcher       Matcher
+	// fieldNames is the field or JSON matcher in matcher.
+	fieldNames fieldNamesMatcher
+	// The number of matched lines after the found line. A match can have
+	// some lines in Multiline.
+	followingMatchLines int
+	hexMatch            hexMatch
+	// The last line number returned by paragraph. Context lines shared with
+	// the previous paragraph aren't returned again.
+	lastParagraphLineNumber LineNumber
 }
 
 type Chapter interface {
@@ -44,19 +85,50 @@ type chapter struct {
 	executor      goseq.Executor
 	afterContext  int
 	beforeContext int
+	invertMatch   bool
 	foundHandler  FoundHandler
-
-	pages       []Page
-	futures     []goseq.Future
-	matchers    []Matcher
+	errorHandler  ErrorHandler
+
+	pages    []Page
+	futures  []goseq.Future
+	matchers []Matcher
+	// fieldMatchers are field matchers in matchers. They need a header.
+	fieldMatchers []*fieldMatcher
+	// jsonMatchers are JSON matchers in matchers. Lines which aren't JSON
+	// are skipped when inverted.
+	jsonMatchers []*jsonMatcher
+	// lineErrors receive errors of matching the current line of each page.
+	lineErrors  []*lineError
 	foundParams []FoundParams
 	foundCounts []FoundCountType
+	// Patterns which matched lines in each page. This is set while checking
+	// AllMatch.
+	patternHits [][]bool
 
 	newInputFunc func() (Input, error)
+	// Input is read twice when this is set. e.g. AllMatch
+	rereadInput bool
+
+	// Pages emit found lines in the order of pages when maxCount is set or
+	// context lines are printed. emitSequence is the sequence number of the
+	// page which can emit lines.
+	emitsInOrder     bool
+	maxCount         FoundCountType
+	stopOnFirstMatch bool
+	emittedCount     FoundCountType
+	emitSequence     int
+	emitCond         *sync.Cond
+	pageSequences    []int
+	// lastParagraphLineNumber is the last line number printed by pages. It
+	// is passed from a page to the next one in the emit order.
+	lastParagraphLineNumber LineNumber
+	input                   Input
+	stopped                 int32
 }
 
 type chapterStdin struct {
 	chapter
+	buffer []byte
 }
 
 type chapterFile struct {
@@ -81,39 +153,240 @@ func NewChapterBytes(bytes []byte) Chapter {
 }
 
 func DefaultFoundHandler(params *FoundParams) {
-	for _, lineBytes := range params.page.LineBytesBeforeAndAfter(
-		params.linePosInPage, params.BeforeContextLength, params.AfterContextLength) {
+	for _, lineBytes := range params.paragraph() {
 		if lineBytes != nil {
-			fmt.Println(string(lineBytes))
+			params.println(string(lineBytes))
 		}
 	}
 }
 
+// StderrErrorHandler prints the file name, the line number and err to
+// stderr.
+func StderrErrorHandler(params *FoundParams, err error) {
+	if len(params.file) > 0 {
+		fmt.Fprint(os.Stderr, params.file, ": ")
+	}
+	fmt.Fprint(os.Stderr, params.lineNumber, ": ", err, "\n")
+}
+
 func LineNumberFoundHandler(params *FoundParams) {
-	for _, lineBytes := range params.page.LineBytesBeforeAndAfter(
-		params.linePosInPage, params.BeforeContextLength, params.AfterContextLength) {
+	for i, lineBytes := range params.paragraph() {
 		if lineBytes != nil {
-			fmt.Println(params.lineNumber, ": ", string(lineBytes))
+			params.println(params.paragraphLineNumber(i), ": ", string(lineBytes))
 		}
 	}
 }
 
 func FileNameFoundHandler(params *FoundParams) {
-	for _, lineBytes := range params.page.LineBytesBeforeAndAfter(
-		params.linePosInPage, params.BeforeContextLength, params.AfterContextLength) {
+	for _, lineBytes := range params.paragraph() {
 		if lineBytes != nil {
-			fmt.Println(params.file, ": ", string(lineBytes))
+			params.println(params.file, ": ", string(lineBytes))
 		}
 	}
 }
 
 func FileNameLineNumberFoundHandler(params *FoundParams) {
-	for _, lineBytes := range params.page.LineBytesBeforeAndAfter(
-		params.linePosInPage, params.BeforeContextLength, params.AfterContextLength) {
+	for i, lineBytes := range params.paragraph() {
 		if lineBytes != nil {
-			fmt.Println(params.file, ": ", params.lineNumber, ": ", string(lineBytes))
+			params.println(params.file, ": ", params.paragraphLineNumber(i), ": ", string(lineBytes))
+		}
+	}
+}
+
+func OnlyMatchingFoundHandler(params *FoundParams) {
+	line := params.LineBytes()
+	for _, span := range nonOverlappingSpans(params.MatchSpans()) {
+		params.printlnSpan(span, string(line[span.Start:span.End]))
+	}
+}
+
+func LineNumberOnlyMatchingFoundHandler(params *FoundParams) {
+	line := params.LineBytes()
+	for _, span := range nonOverlappingSpans(params.MatchSpans()) {
+		params.printlnSpan(span, params.lineNumber, ": ", string(line[span.Start:span.End]))
+	}
+}
+
+func FileNameOnlyMatchingFoundHandler(params *FoundParams) {
+	line := params.LineBytes()
+	for _, span := range nonOverlappingSpans(params.MatchSpans()) {
+		params.printlnSpan(span, params.file, ": ", string(line[span.Start:span.End]))
+	}
+}
+
+func FileNameLineNumberOnlyMatchingFoundHandler(params *FoundParams) {
+	line := params.LineBytes()
+	for _, span := range nonOverlappingSpans(params.MatchSpans()) {
+		params.printlnSpan(span, params.file, ": ", params.lineNumber, ": ", string(line[span.Start:span.End]))
+	}
+}
+
+// nonOverlappingSpans picks the leftmost longest spans which don't overlap
+// each other from spans ordered by Start. Empty spans are dropped.
+func nonOverlappingSpans(spans []MatchSpan) []MatchSpan {
+	result := make([]MatchSpan, 0, len(spans))
+	for _, span := range spans {
+		if span.Start == span.End {
+			continue
+		}
+		n := len(result)
+		if n > 0 && result[n-1].Start == span.Start {
+			if result[n-1].End < span.End {
+				result[n-1] = span
+			}
+		} else if n == 0 || result[n-1].End <= span.Start {
+			result = append(result, span)
+		}
+	}
+	return result
+}
+
+// paragraph returns the found lines with their context lines. Lines which
+// the previous paragraph has returned are nil, so overlapped context lines
+// are printed once.
+func (params *FoundParams) paragraph() []LineBytes {
+	lines := params.page.LineBytesBeforeAndAfter(params.linePosInPage,
+		params.BeforeContextLength, params.AfterContextLength+params.followingMatchLines)
+	for i := range lines {
+		lineNumber := params.paragraphLineNumber(i)
+		if lineNumber <= params.lastParagraphLineNumber {
+			lines[i] = nil
+		} else if lines[i] != nil {
+			params.lastParagraphLineNumber = lineNumber
+		}
+	}
+	return lines
+}
+
+// paragraphLineNumber returns the line number of paragraph()[index].
+func (params *FoundParams) paragraphLineNumber(index int) LineNumber {
+	return params.lineNumber + LineNumber(index) - LineNumber(params.BeforeContextLength)
+}
+
+// LineBytes returns the found line.
+func (params *FoundParams) LineBytes() LineBytes {
+	return params.page.LineBytesAt(params.linePosInPage)
+}
+
+// MatchSpans returns matched ranges in the found line. A line selected by
+// InvertMatch doesn't have any matched range.
+func (params *FoundParams) MatchSpans() []MatchSpan {
+	if params.InvertMatch || params.matcher == nil {
+		return nil
+	}
+	return params.matcher.MatchSpans(params.LineBytes())
+}
+
+// Distance returns the edit distance of the nearest match in the found line
+// when MaxErrors is set. Otherwise, it returns 0 for a matched line. A line
+// selected by InvertMatch returns -1.
+func (params *FoundParams) Distance() int {
+	if params.InvertMatch || params.matcher == nil {
+		return -1
+	}
+	if m, ok := params.matcher.(distanceMatcher); ok {
+		return m.Distance(params.LineBytes())
+	}
+	return 0
+}
+
+// patternCount returns the number of patterns including TypedMatchers and
+// conditions of Logfmt.
+func (params *FindParams) patternCount() int {
+	n := len(params.Patterns) + len(params.TypedMatchers)
+	if params.Logfmt != nil {
+		n += len(params.Logfmt.Conditions)
+	}
+	return n
+}
+
+// MatchedPatternIndexes returns indexes of patterns which match the found
+// line in ascending order. TypedMatchers[i] has the index len(Patterns)+i,
+// and conditions of Logfmt follow them.
+func (params *FoundParams) MatchedPatternIndexes() []int {
+	spans := params.MatchSpans()
+	found := make([]bool, params.patternCount())
+	indexes := make([]int, 0, len(spans))
+	for _, span := range spans {
+		found[span.PatternIndex] = true
+	}
+	for i, ok := range found {
+		if ok {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// MatchedPatternLabels returns labels of patterns which match the found
+// line. A pattern itself is used when PatternLabels doesn't have its label.
+func (params *FoundParams) MatchedPatternLabels() []string {
+	if len(params.HexPatterns) > 0 {
+		return []string{params.HexPatterns[params.hexMatch.patternIndex].String()}
+	}
+	indexes := params.MatchedPatternIndexes()
+	labels := make([]string, len(indexes))
+	for i, patternIndex := range indexes {
+		labels[i] = params.patternLabel(patternIndex)
+	}
+	return labels
+}
+
+// patternLabel returns the label of the pattern at patternIndex.
+func (params *FoundParams) patternLabel(patternIndex int) string {
+	if patternIndex < len(params.PatternLabels) && params.PatternLabels[patternIndex] != "" {
+		return params.PatternLabels[patternIndex]
+	} else if typedIndex := patternIndex - len(params.Patterns); typedIndex >= len(params.TypedMatchers) {
+		return params.Logfmt.Conditions[typedIndex-len(params.TypedMatchers)].String()
+	} else if typedIndex >= 0 {
+		return params.TypedMatchers[typedIndex].String()
+	}
+	return params.Patterns[patternIndex]
+}
+
+// MatchedFields returns names of fields which match the found line when
+// Fields or JSON is set. A field is named by its 1-based index without a
+// header, and a JSON value is named by its path.
+func (params *FoundParams) MatchedFields() []string {
+	if params.InvertMatch {
+		return nil
+	}
+	if params.fieldNames == nil {
+		return nil
+	}
+	return params.fieldNames.matchedFields(params.LineBytes())
+}
+
+// println prints a line of found text. Labels of matched patterns are
+// printed first if PrintPatternLabel is set, and then matched fields.
+func (params *FoundParams) println(a ...interface{}) {
+	var labels []string
+	if params.PrintPatternLabel {
+		labels = params.MatchedPatternLabels()
+	}
+	params.printlnLabeled(labels, a...)
+}
+
+// printlnSpan prints a matched part of the found line. It is labeled with
+// the pattern of span only.
+func (params *FoundParams) printlnSpan(span MatchSpan, a ...interface{}) {
+	var labels []string
+	if params.PrintPatternLabel {
+		labels = []string{params.patternLabel(span.PatternIndex)}
+	}
+	params.printlnLabeled(labels, a...)
+}
+
+func (params *FoundParams) printlnLabeled(labels []string, a ...interface{}) {
+	if params.Fields != nil || params.JSON != nil {
+		if fields := params.MatchedFields(); len(fields) > 0 {
+			a = append([]interface{}{strings.Join(fields, ","), ": "}, a...)
 		}
 	}
+	if len(labels) > 0 {
+		a = append([]interface{}{strings.Join(labels, ","), ": "}, a...)
+	}
+	fmt.Println(a...)
 }
 
 func newChapterStdin() (c *chapterStdin) {
@@ -151,77 +424,331 @@ func (c *chapter) findPageText(pageIndex PageIndex) (count FoundCountType, err e
 	currentPage := c.pages[pageIndex]
 	currentPageLen := currentPage.Length()
 	foundHandler := c.foundHandler
-	for i := 0; i < currentPageLen; i++ {
+	for i := 0; i < currentPageLen && !c.isStopped(); i++ {
 		line := currentPage.LineBytesAt(i)
-		if c.matchers[pageIndex].Match(line) {
+		if c.patternHits != nil {
+			for _, span := range c.matchers[pageIndex].MatchSpans(line) {
+				c.patternHits[pageIndex][span.PatternIndex] = true
+			}
+			continue
+		}
+		if c.selects(pageIndex, i) {
 			count++
 			if foundHandler != nil {
-				c.foundParams[pageIndex].page = currentPage
-				c.foundParams[pageIndex].linePosInPage = i
-				c.foundParams[pageIndex].lineNumber = currentPage.StartLineNumber() + LineNumber(i) + lineNumberStartAt
+				c.setFoundLine(pageIndex, i)
 				foundHandler(&(c.foundParams[pageIndex]))
 			}
+			if c.stopOnFirstMatch {
+				c.stop()
+			}
 		}
 	}
 	c.foundCounts[pageIndex] += count
 	return
 }
 
+// findPageTextInOrder matches lines of the page in parallel with other
+// pages, but it waits for the previous pages to emit their lines. So only
+// the first maxCount selected lines are emitted, and context lines shared
+// with the previous page are printed once. The input is stopped when
+// maxCount lines are emitted.
+func (c *chapter) findPageTextInOrder(pageIndex PageIndex, sequence int) (count FoundCountType, err error) {
+	currentPage := c.pages[pageIndex]
+	var selected []int
+	for i := 0; i < currentPage.Length() && !c.isStopped(); i++ {
+		if c.selects(pageIndex, i) {
+			selected = append(selected, i)
+		}
+	}
+
+	c.emitCond.L.Lock()
+	for c.emitSequence != sequence {
+		c.emitCond.Wait()
+	}
+	c.emitCond.L.Unlock()
+
+	c.foundParams[pageIndex].lastParagraphLineNumber = c.lastParagraphLineNumber
+	for _, i := range selected {
+		if c.maxCount > 0 && c.emittedCount >= c.maxCount {
+			break
+		}
+		c.emittedCount++
+		count++
+		if c.foundHandler != nil {
+			c.setFoundLine(pageIndex, i)
+			c.foundHandler(&(c.foundParams[pageIndex]))
+		}
+	}
+	c.lastParagraphLineNumber = c.foundParams[pageIndex].lastParagraphLineNumber
+	if c.maxCount > 0 && c.emittedCount >= c.maxCount {
+		c.stop()
+	}
+
+	c.emitCond.L.Lock()
+	c.emitSequence++
+	c.emitCond.Broadcast()
+	c.emitCond.L.Unlock()
+
+	c.foundCounts[pageIndex] += count
+	return
+}
+
+// selects checks the line is selected. Selected lines are the matched ones,
+// or the unmatched ones when inverted. Inverted lines must be JSON with JSON
+// params.
+func (c *chapter) selects(pageIndex PageIndex, i int) bool {
+	line := c.pages[pageIndex].LineBytesAt(i)
+	c.lineErrors[pageIndex].err = nil
+	matched := c.matchers[pageIndex].Match(line)
+	if err := c.lineErrors[pageIndex].err; err != nil && !matched {
+		if c.errorHandler != nil {
+			c.setFoundLine(pageIndex, i)
+			c.errorHandler(&(c.foundParams[pageIndex]), err)
+		}
+		return false
+	}
+	if matched == c.invertMatch {
+		return false
+	}
+	return !c.invertMatch || c.jsonMatchers[pageIndex] == nil || c.jsonMatchers[pageIndex].isJSON(line)
+}
+
+// setFoundLine sets the line at i of the page to foundParams.
+func (c *chapter) setFoundLine(pageIndex PageIndex, i int) {
+	currentPage := c.pages[pageIndex]
+	c.foundParams[pageIndex].page = currentPage
+	c.foundParams[pageIndex].linePosInPage = i
+	c.foundParams[pageIndex].lineNumber = currentPage.StartLineNumber() + LineNumber(i) + lineNumberStartAt
+}
+
 func (c *chapter) submitPage(pageIndex PageIndex) (oldFuture goseq.Future) {
 	if c.futures[pageIndex] != nil {
 		oldFuture = c.futures[pageIndex]
 		oldFuture.Result()
 	}
+	if c.emitsInOrder {
+		sequence := c.pageSequences[pageIndex]
+		c.futures[pageIndex] = c.executor.Execute(func() (goseq.Any, error) {
+			return c.findPageTextInOrder(pageIndex, sequence)
+		})
+		return
+	}
 	c.futures[pageIndex] = c.executor.Execute(func() (goseq.Any, error) {
 		return c.findPageText(pageIndex)
 	})
 	return
 }
 
-// TODO: This should be able to break searching files when gorep only need to find a line or not.
+// stop stops reading the input. Pages which aren't emitted yet skip their
+// lines.
+func (c *chapter) stop() {
+	atomic.StoreInt32(&c.stopped, 1)
+	c.input.Stop()
+}
+
+func (c *chapter) isStopped() bool {
+	return atomic.LoadInt32(&c.stopped) != 0
+}
+
+// Find calls findParams.Handler for each selected line and returns the
+// number of selected lines. When StopOnFirstMatch is set, reading the input
+// stops at the first selected line and it returns 1 or 0. e.g. -q, -l, -L
+// When HexPatterns is set, it calls the handler for each found byte sequence
+// instead of lines.
 func (c *chapter) Find(findParams *FindParams) FoundCountType {
 	if findParams == nil {
 		return 0
 	}
 
+	if len(findParams.HexPatterns) > 0 {
+		return c.findHex(findParams)
+	}
+
+	if findParams.Multiline {
+		if findParams.StopOnFirstMatch {
+			params := *findParams
+			params.MaxCount = 1
+			return c.findMultiline(&params)
+		}
+		return c.findMultiline(findParams)
+	}
+
+	if findParams.AllMatch {
+		c.rereadInput = true
+		if !c.matchesAllPatterns(findParams) {
+			return 0
+		}
+	}
+
+	return c.find(findParams)
+}
+
+// matchesAllPatterns reads the input without a handler and checks each
+// pattern matches a line at least. Patterns under --not of Expression are
+// not checked.
+func (c *chapter) matchesAllPatterns(findParams *FindParams) bool {
+	params := *findParams
+	params.Handler = nil
+	params.MaxCount = 0
+	params.StopOnFirstMatch = false
+	c.patternHits = make([][]bool, c.parallelCount)
+	for i := range c.patternHits {
+		c.patternHits[i] = make([]bool, params.patternCount())
+	}
+	defer func() {
+		c.patternHits = nil
+	}()
+
+	c.find(&params)
+
+	required := make([]bool, params.patternCount())
+	if params.Expression != nil {
+		params.Expression.markPositivePatterns(required)
+	} else {
+		for i := range required {
+			required[i] = true
+		}
+	}
+
+	for patternIndex, isRequired := range required {
+		hit := false
+		for _, hits := range c.patternHits {
+			hit = hit || hits[patternIndex]
+		}
+		if isRequired && !hit {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *chapter) find(findParams *FindParams) FoundCountType {
 	c.afterContext = findParams.AfterContextLength
 	c.beforeContext = findParams.BeforeContextLength
+	c.invertMatch = findParams.InvertMatch
 	c.foundHandler = findParams.Handler
-	pageBufSize := 1024 * 2
+	c.errorHandler = findParams.ErrorHandler
+	c.maxCount = FoundCountType(findParams.MaxCount)
+	c.stopOnFirstMatch = findParams.StopOnFirstMatch
+	if c.stopOnFirstMatch {
+		c.maxCount = 0
+	}
+	c.emitsInOrder = c.maxCount > 0 || (!c.stopOnFirstMatch && (c.afterContext > 0 || c.beforeContext > 0))
+	c.lastParagraphLineNumber = 0
+	c.emittedCount = 0
+	c.emitSequence = 0
+	c.emitCond = sync.NewCond(new(sync.Mutex))
+	c.stopped = 0
 	pageCounter := 0
 	pageIndex := PageIndex(0)
 
 	c.pages = make([]Page, c.parallelCount)
 	c.futures = make([]goseq.Future, c.parallelCount)
 	c.matchers = make([]Matcher, c.parallelCount)
+	c.fieldMatchers = make([]*fieldMatcher, c.parallelCount)
+	c.jsonMatchers = make([]*jsonMatcher, c.parallelCount)
+	c.lineErrors = make([]*lineError, c.parallelCount)
 	c.foundCounts = make([]FoundCountType, c.parallelCount)
 	c.foundParams = make([]FoundParams, c.parallelCount)
+	c.pageSequences = make([]int, c.parallelCount)
 
 	for i := len(c.pages) - 1; i >= 0; i-- {
-		c.pages[i] = NewPage(&PageParams{Capacity: pageBufSize})
+		c.pages[i] = NewPage(&PageParams{Capacity: pageCapacity})
 		c.pages[i].Reset()
-		if findParams.FixedStrings {
-			c.matchers[i] = NewBytesMatcher(findParams.Patterns, findParams.IgnoreCase)
+		matcherParams := &MatcherParams{
+			Patterns:     findParams.Patterns,
+			IgnoreCase:   findParams.IgnoreCase,
+			IgnoreCases:  findParams.IgnoreCases,
+			FixedStrings: findParams.FixedStrings,
+			WordRegexp:   findParams.WordRegexp,
+			LineRegexp:   findParams.LineRegexp,
+			MaxErrors:    findParams.MaxErrors,
+			PerlRegexp:   findParams.PerlRegexp,
+			LongestMatch: findParams.LongestMatch,
+			lineError:    new(lineError),
+		}
+		c.lineErrors[i] = matcherParams.lineError
+		if findParams.Expression != nil {
+			c.matchers[i] = NewExpressionMatcher(findParams.Expression, matcherParams)
 		} else {
-			c.matchers[i] = NewRegexpMatcher(findParams.Patterns, findParams.IgnoreCase)
+			c.matchers[i] = NewMatcher(matcherParams)
+		}
+		if len(findParams.TypedMatchers) > 0 {
+			c.matchers[i] = newTypedPatternMatcher(c.matchers[i], findParams.TypedMatchers, len(findParams.Patterns))
+		}
+		if findParams.Fields != nil {
+			c.fieldMatchers[i] = newFieldMatcher(c.matchers[i], findParams.Fields)
+			c.matchers[i] = c.fieldMatchers[i]
+			c.foundParams[i].fieldNames = c.fieldMatchers[i]
+		} else if findParams.JSON != nil {
+			c.jsonMatchers[i] = newJSONMatcher(c.matchers[i], findParams.JSON)
+			c.matchers[i] = c.jsonMatchers[i]
+			c.foundParams[i].fieldNames = c.jsonMatchers[i]
+		} else if findParams.Logfmt != nil {
+			hasPatterns := len(findParams.Patterns) > 0 || len(findParams.TypedMatchers) > 0
+			c.matchers[i] = newLogfmtMatcher(c.matchers[i], findParams.Logfmt, matcherParams, hasPatterns,
+				len(findParams.Patterns)+len(findParams.TypedMatchers))
+		}
+		if findParams.TimeRange != nil {
+			hasPatterns := len(findParams.Patterns) > 0 || len(findParams.TypedMatchers) > 0 || findParams.Logfmt != nil
+			// Later lines of sorted input are out of the range, so only
+			// reading input is stopped. Read pages are still searched.
+			// They are selected when inverted, so the input is read to the end.
+			var passed func()
+			if !findParams.InvertMatch {
+				passed = func() {
+					c.input.Stop()
+				}
+			}
+			c.matchers[i] = newTimeRangeMatcher(c.matchers[i], findParams.TimeRange, hasPatterns, passed)
 		}
 		c.foundParams[i].AfterContextLength = findParams.AfterContextLength
 		c.foundParams[i].BeforeContextLength = findParams.BeforeContextLength
 		c.foundParams[i].FixedStrings = findParams.FixedStrings
 		c.foundParams[i].Handler = findParams.Handler
 		c.foundParams[i].IgnoreCase = findParams.IgnoreCase
+		c.foundParams[i].IgnoreCases = findParams.IgnoreCases
+		c.foundParams[i].InvertMatch = findParams.InvertMatch
+		c.foundParams[i].WordRegexp = findParams.WordRegexp
+		c.foundParams[i].LineRegexp = findParams.LineRegexp
+		c.foundParams[i].PatternLabels = findParams.PatternLabels
+		c.foundParams[i].PrintPatternLabel = findParams.PrintPatternLabel
+		c.foundParams[i].Expression = findParams.Expression
+		c.foundParams[i].AllMatch = findParams.AllMatch
+		c.foundParams[i].Multiline = findParams.Multiline
+		c.foundParams[i].MaxErrors = findParams.MaxErrors
+		c.foundParams[i].PerlRegexp = findParams.PerlRegexp
+		c.foundParams[i].LongestMatch = findParams.LongestMatch
+		c.foundParams[i].MaxCount = findParams.MaxCount
+		c.foundParams[i].StopOnFirstMatch = findParams.StopOnFirstMatch
+		c.foundParams[i].TypedMatchers = findParams.TypedMatchers
+		c.foundParams[i].Fields = findParams.Fields
+		c.foundParams[i].JSON = findParams.JSON
+		c.foundParams[i].Logfmt = findParams.Logfmt
+		c.foundParams[i].TimeRange = findParams.TimeRange
 		c.foundParams[i].Patterns = findParams.Patterns
 		c.foundParams[i].file = c.file
+		c.foundParams[i].matcher = c.matchers[i]
 	}
 
 	input, _ := c.createInput()
 	defer input.Close()
+	c.input = input
 
 	deferredPageIndex := PageIndex(0)
 	deferred := false
 	lineNum := LineNumber(0)
+	header := findParams.Fields != nil && findParams.Fields.hasHeader()
 
 	input.EachLineBytes(func(line []byte) {
+		if header {
+			header = false
+			c.setHeader(line)
+			lineNum++
+			c.pages[pageIndex].SetStartLineNumber(lineNum)
+			return
+		}
+
 		currentPage := c.pages[pageIndex]
 		if !currentPage.IsEnoughCapacity() {
 			previousPage := currentPage
@@ -229,6 +756,9 @@ func (c *chapter) Find(findParams *FindParams) FoundCountType {
 			if findParams.AfterContextLength == 0 {
 				c.submitPage(pageIndex)
 			} else {
+				if deferred {
+					c.submitPage(deferredPageIndex)
+				}
 				deferred = true
 				deferredPageIndex = pageIndex
 			}
@@ -236,9 +766,14 @@ func (c *chapter) Find(findParams *FindParams) FoundCountType {
 			pageCounter++
 			pageIndex = PageIndex(pageCounter % c.parallelCount)
 			currentPage = c.pages[pageIndex]
+			// The page can be reused after its search is done.
+			if c.futures[pageIndex] != nil {
+				c.futures[pageIndex].Result()
+			}
 			currentPage.Reset()
 			currentPage.SetStartLineNumber(lineNum)
 			previousPage.Next(currentPage)
+			c.pageSequences[pageIndex] = pageCounter
 		}
 
 		currentPage.AddLineBytes(line)
@@ -266,10 +801,21 @@ func (c *chapter) Find(findParams *FindParams) FoundCountType {
 	for _, count := range c.foundCounts {
 		totalFoundCount += count
 	}
+	// Some pages can find lines at the same time.
+	if c.stopOnFirstMatch && totalFoundCount > 1 {
+		totalFoundCount = 1
+	}
 
 	return totalFoundCount
 }
 
+// setHeader resolves columns of fieldMatchers with the header line.
+func (c *chapter) setHeader(line []byte) {
+	for _, m := range c.fieldMatchers {
+		m.setHeader(line)
+	}
+}
+
 func (c *chapter) Close() error {
 	c.executor.Stop()
 	return nil
@@ -280,7 +826,16 @@ func (c *chapter) createInput() (Input, error) {
 }
 
 func (c *chapterStdin) newInput() (Input, error) {
-	return NewStdinInput()
+	if !c.rereadInput {
+		return NewStdinInput()
+	}
+
+	var err error
+	if c.buffer == nil {
+		c.buffer, err = ioutil.ReadAll(os.Stdin)
+	}
+	input, _ := NewBytesInput(c.buffer)
+	return input, err
 }
 
 func (c *chapterFile) newInput() (Input, error) {
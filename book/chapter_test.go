@@ -1,6 +1,7 @@
 package book
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -22,3 +23,311 @@ func TestFind(t *testing.T) {
 	})
 
 }
+
+func TestFindInvertMatch(t *testing.T) {
+	c := newChapterBytes([]byte("foo\nbar\nbaz\n"))
+	defer c.Close()
+	lines := make([]string, 0)
+	count := c.Find(&FindParams{
+		Patterns:    []string{"foo"},
+		InvertMatch: true,
+		Handler: func(params *FoundParams) {
+			lines = append(lines, string(params.page.LineBytesAt(params.linePosInPage)))
+		},
+	})
+	if count != 2 {
+		t.Error("Find should count lines not matching the pattern.", count)
+	}
+	if len(lines) != 2 || lines[0] != "bar" || lines[1] != "baz" {
+		t.Error("Find should call handler for lines not matching the pattern.", lines)
+	}
+
+	count = c.Find(&FindParams{
+		Patterns:     []string{"ba"},
+		FixedStrings: true,
+		InvertMatch:  true,
+	})
+	if count != 1 {
+		t.Error("Find should count lines not matching the fixed string.", count)
+	}
+}
+
+func TestFoundParamsMatchSpans(t *testing.T) {
+	c := newChapterBytes([]byte("foo bar foo\nbar\n"))
+	defer c.Close()
+	var spans []MatchSpan
+	c.Find(&FindParams{
+		Patterns: []string{"foo"},
+		Handler: func(params *FoundParams) {
+			spans = params.MatchSpans()
+		},
+	})
+	if len(spans) != 2 || spans[0] != (MatchSpan{0, 3, 0}) || spans[1] != (MatchSpan{8, 11, 0}) {
+		t.Error("FoundParams should provide matched spans.", spans)
+	}
+}
+
+func TestNonOverlappingSpans(t *testing.T) {
+	spans := nonOverlappingSpans([]MatchSpan{
+		{0, 0, 0}, {0, 2, 0}, {0, 3, 1}, {2, 4, 0}, {3, 5, 1}, {6, 7, 0}})
+	if len(spans) != 3 || spans[0] != (MatchSpan{0, 3, 1}) ||
+		spans[1] != (MatchSpan{3, 5, 1}) || spans[2] != (MatchSpan{6, 7, 0}) {
+		t.Error("nonOverlappingSpans returns wrong spans.", spans)
+	}
+}
+
+func TestFoundParamsMatchedPatternLabels(t *testing.T) {
+	c := newChapterBytes([]byte("foo bar\nbaz\n"))
+	defer c.Close()
+	var indexes [][]int
+	var labels [][]string
+	var spanLabels []string
+	c.Find(&FindParams{
+		Patterns:      []string{"ba.", "foo", "hoge"},
+		PatternLabels: []string{"", "FOO", "HOGE"},
+		Handler: func(params *FoundParams) {
+			indexes = append(indexes, params.MatchedPatternIndexes())
+			labels = append(labels, params.MatchedPatternLabels())
+			for _, span := range nonOverlappingSpans(params.MatchSpans()) {
+				spanLabels = append(spanLabels, params.patternLabel(span.PatternIndex))
+			}
+		},
+	})
+	if len(indexes) != 2 || len(indexes[0]) != 2 || indexes[0][0] != 0 || indexes[0][1] != 1 ||
+		len(indexes[1]) != 1 || indexes[1][0] != 0 {
+		t.Error("MatchedPatternIndexes returns wrong indexes.", indexes)
+	}
+	if len(labels) != 2 || len(labels[0]) != 2 || labels[0][0] != "ba." || labels[0][1] != "FOO" {
+		t.Error("MatchedPatternLabels returns wrong labels.", labels)
+	}
+	if len(spanLabels) != 3 || spanLabels[0] != "FOO" || spanLabels[1] != "ba." || spanLabels[2] != "ba." {
+		t.Error("A matched part should be labeled with its own pattern.", spanLabels)
+	}
+}
+
+func TestFindExpression(t *testing.T) {
+	c := newChapterBytes([]byte("foo\nfoo bar\nbar\n"))
+	defer c.Close()
+	expr, patterns, _ := ParseExpression("foo --and --not bar")
+	count := c.Find(&FindParams{Patterns: patterns, Expression: expr})
+	if count != 1 {
+		t.Error("Find should select lines by expression.", count)
+	}
+}
+
+func TestFindAllMatch(t *testing.T) {
+	c := newChapterBytes([]byte("foo\nbar\nfoo bar\n"))
+	defer c.Close()
+	count := c.Find(&FindParams{Patterns: []string{"foo", "bar"}, AllMatch: true})
+	if count != 3 {
+		t.Error("Find should count lines when all patterns match.", count)
+	}
+	lines := 0
+	count = c.Find(&FindParams{
+		Patterns: []string{"foo", "baz"},
+		AllMatch: true,
+		Handler: func(params *FoundParams) {
+			lines++
+		},
+	})
+	if count != 0 || lines != 0 {
+		t.Error("Find shouldn't select lines when a pattern doesn't match.", count, lines)
+	}
+}
+
+func TestFindAllMatchExpression(t *testing.T) {
+	c := newChapterBytes([]byte("foo\nbar\n"))
+	defer c.Close()
+	expr, patterns, _ := ParseExpression("foo --or bar --or --not baz")
+	count := c.Find(&FindParams{Patterns: patterns, Expression: expr, AllMatch: true})
+	if count != 2 {
+		t.Error("Find shouldn't require patterns under --not.", count)
+	}
+}
+
+func TestFindInvertMatchOverlappedContext(t *testing.T) {
+	c := newChapterBytes([]byte("a\nfoo\nb\nc\nfoo\n"))
+	defer c.Close()
+	var lineNumbers []LineNumber
+	c.Find(&FindParams{
+		Patterns:            []string{"foo"},
+		InvertMatch:         true,
+		BeforeContextLength: 1,
+		AfterContextLength:  1,
+		Handler: func(params *FoundParams) {
+			for i, line := range params.paragraph() {
+				if line != nil {
+					lineNumbers = append(lineNumbers, params.paragraphLineNumber(i))
+				}
+			}
+		},
+	})
+	if len(lineNumbers) != 5 {
+		t.Fatal("Overlapped context lines should be printed once.", lineNumbers)
+	}
+	for i, n := range lineNumbers {
+		if n != LineNumber(i+1) {
+			t.Error("Lines should be printed in order.", lineNumbers)
+			break
+		}
+	}
+}
+
+func TestFindOverlappedContextOverPages(t *testing.T) {
+	lines := make([]string, pageCapacity*2)
+	for i := range lines {
+		lines[i] = "a"
+	}
+	lines[pageCapacity-1] = "foo"
+	lines[pageCapacity] = "foo"
+	c := newChapterBytes([]byte(strings.Join(lines, "\n") + "\n"))
+	defer c.Close()
+	var lineNumbers []LineNumber
+	c.Find(&FindParams{
+		Patterns:            []string{"foo"},
+		BeforeContextLength: 2,
+		AfterContextLength:  2,
+		Handler: func(params *FoundParams) {
+			for i, line := range params.paragraph() {
+				if line != nil {
+					lineNumbers = append(lineNumbers, params.paragraphLineNumber(i))
+				}
+			}
+		},
+	})
+	if len(lineNumbers) != 6 {
+		t.Fatal("Context lines shared by pages should be printed once.", lineNumbers)
+	}
+	for i, n := range lineNumbers {
+		if n != LineNumber(pageCapacity-2+i) {
+			t.Error("Lines should be printed in order.", lineNumbers)
+			break
+		}
+	}
+}
+
+func TestFoundParamsParagraphLineNumber(t *testing.T) {
+	c := newChapterBytes([]byte("a\nb\nc\nd\n"))
+	defer c.Close()
+	var lineNumbers []LineNumber
+	c.Find(&FindParams{
+		Patterns:            []string{"c"},
+		BeforeContextLength: 2,
+		AfterContextLength:  1,
+		Handler: func(params *FoundParams) {
+			for i := range params.paragraph() {
+				lineNumbers = append(lineNumbers, params.paragraphLineNumber(i))
+			}
+		},
+	})
+	if len(lineNumbers) != 4 || lineNumbers[0] != 1 || lineNumbers[2] != 3 || lineNumbers[3] != 4 {
+		t.Error("paragraphLineNumber returns wrong line numbers.", lineNumbers)
+	}
+}
+
+func TestFoundParamsDistance(t *testing.T) {
+	c := newChapterBytes([]byte("color\ncolour\ncolr\n"))
+	defer c.Close()
+	var distances []int
+	c.Find(&FindParams{
+		Patterns:  []string{"color"},
+		MaxErrors: 1,
+		Handler: func(params *FoundParams) {
+			distances = append(distances, params.Distance())
+		},
+	})
+	if len(distances) != 3 || distances[0] != 0 || distances[1] != 1 || distances[2] != 1 {
+		t.Error("Distance returns wrong values.", distances)
+	}
+}
+
+func TestFindMaxCount(t *testing.T) {
+	text := strings.Repeat("foo\nbar\n", 5000)
+	c := newChapterBytes([]byte(text))
+	defer c.Close()
+	var lineNumbers []LineNumber
+	count := c.Find(&FindParams{
+		Patterns: []string{"foo"},
+		MaxCount: 3000,
+		Handler: func(params *FoundParams) {
+			lineNumbers = append(lineNumbers, params.lineNumber)
+		},
+	})
+	if count != 3000 || len(lineNumbers) != 3000 {
+		t.Error("Find should stop after MaxCount lines.", count, len(lineNumbers))
+	}
+	for i, n := range lineNumbers {
+		if n != LineNumber(2*i+1) {
+			t.Error("Find should emit lines in order with MaxCount.", i, n)
+			break
+		}
+	}
+
+	count = c.Find(&FindParams{Patterns: []string{"foo"}, InvertMatch: true, MaxCount: 2})
+	if count != 2 {
+		t.Error("MaxCount should count inverted lines.", count)
+	}
+}
+
+func TestFindMaxCountAfterContext(t *testing.T) {
+	c := newChapterBytes([]byte("foo1\nbar\nfoo2\nbaz\nqux\nfoo3\n"))
+	defer c.Close()
+	var paragraphs []string
+	count := c.Find(&FindParams{
+		Patterns:           []string{"foo"},
+		MaxCount:           2,
+		AfterContextLength: 2,
+		Handler: func(params *FoundParams) {
+			var lines []string
+			for _, line := range params.paragraph() {
+				if line != nil {
+					lines = append(lines, string(line))
+				}
+			}
+			paragraphs = append(paragraphs, strings.Join(lines, ","))
+		},
+	})
+	// foo2 is printed as the context of foo1, so the second paragraph has
+	// only its after context. The output is the same as grep -m 2 -A 2.
+	if count != 2 || strings.Join(paragraphs, ",") != "foo1,bar,foo2,baz,qux" {
+		t.Error("Context after the last line should be printed.", count, paragraphs)
+	}
+}
+
+func TestFindMaxCountStopsInput(t *testing.T) {
+	c := newChapterBytes([]byte(strings.Repeat("foo\n", 100000)))
+	defer c.Close()
+	var input Input
+	c.newInputFunc = func() (Input, error) {
+		input, _ = NewBytesInput(c.bytes)
+		return input, nil
+	}
+	if count := c.Find(&FindParams{Patterns: []string{"foo"}, MaxCount: 1}); count != 1 {
+		t.Error("Find should count a line.", count)
+	}
+	if input.(*bytesInput).stopped == 0 {
+		t.Error("Input should be stopped.")
+	}
+}
+
+func TestFindStopOnFirstMatch(t *testing.T) {
+	c := newChapterBytes([]byte(strings.Repeat("foo\n", 100000)))
+	defer c.Close()
+	var input Input
+	c.newInputFunc = func() (Input, error) {
+		input, _ = NewBytesInput(c.bytes)
+		return input, nil
+	}
+	if count := c.Find(&FindParams{Patterns: []string{"foo"}, StopOnFirstMatch: true}); count != 1 {
+		t.Error("Find should return 1 when a line is found.", count)
+	}
+	if input.(*bytesInput).stopped == 0 {
+		t.Error("Input should be stopped.")
+	}
+	if count := c.Find(&FindParams{Patterns: []string{"bar"}, StopOnFirstMatch: true}); count != 0 {
+		t.Error("Find should return 0 when nothing is found.", count)
+	}
+	if count := c.Find(&FindParams{Patterns: []string{"foo"}, Multiline: true, StopOnFirstMatch: true}); count != 1 {
+		t.Error("Find should stop at the first line in Multiline.", count)
+	}
+}
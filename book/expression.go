@@ -0,0 +1,315 @@
+package book
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type ExpressionOp int
+
+const (
+	ExpressionPattern ExpressionOp = iota
+	ExpressionAnd
+	ExpressionOr
+	ExpressionNot
+)
+
+const (
+	expressionTokenAnd     = "--and"
+	expressionTokenOr      = "--or"
+	expressionTokenNot     = "--not"
+	expressionTokenPattern = "-e"
+	expressionTokenOpen    = "("
+	expressionTokenClose   = ")"
+)
+
+// Expression is a tree of boolean operators over patterns.
+type Expression struct {
+	Op ExpressionOp
+	// Index of a pattern for ExpressionPattern.
+	PatternIndex int
+	Children     []*Expression
+}
+
+type expressionParser struct {
+	tokens   []string
+	pos      int
+	patterns []string
+}
+
+type expressionMatcher struct {
+	op           ExpressionOp
+	patternIndex int
+	matcher      Matcher
+	children     []*expressionMatcher
+}
+
+// ParseExpression parses an expression like git grep and returns it with
+// patterns in order of appearance. Patterns are combined by --and, --or,
+// --not and parentheses. --not binds tighter than --and, and --and binds
+// tighter than --or. Patterns without an operator are combined by --or.
+// A pattern which starts with '-' or is a parenthesis should follow -e.
+// Tokens are separated by spaces and can be quoted by ' or ".
+//
+// e.g.
+// expr, patterns, err := ParseExpression("ERROR --and --not ( debug --or 'test case' )")
+func ParseExpression(text string) (*Expression, []string, error) {
+	tokens, err := splitExpressionTokens(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, errors.New("expression is empty")
+	}
+
+	p := &expressionParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos])
+	}
+	return expr, p.patterns, nil
+}
+
+func splitExpressionTokens(text string) ([]string, error) {
+	tokens := make([]string, 0)
+	var token []rune
+	var quote rune
+	inToken := false
+	escaped := false
+
+	for _, r := range text {
+		switch {
+		case escaped:
+			token = append(token, r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inToken = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				token = append(token, r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inToken = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inToken {
+				tokens = append(tokens, string(token))
+				token = token[:0]
+				inToken = false
+			}
+		default:
+			token = append(token, r)
+			inToken = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape in expression")
+	}
+	if inToken {
+		tokens = append(tokens, string(token))
+	}
+	return tokens, nil
+}
+
+func (p *expressionParser) peek() (string, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return "", false
+}
+
+func (p *expressionParser) parseOr() (*Expression, error) {
+	children := make([]*Expression, 0, 1)
+	for {
+		child, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+
+		token, ok := p.peek()
+		if !ok || token == expressionTokenClose {
+			break
+		}
+		if token == expressionTokenOr {
+			p.pos++
+		}
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &Expression{Op: ExpressionOr, Children: children}, nil
+}
+
+func (p *expressionParser) parseAnd() (*Expression, error) {
+	children := make([]*Expression, 0, 1)
+	for {
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+
+		if token, ok := p.peek(); !ok || token != expressionTokenAnd {
+			break
+		}
+		p.pos++
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &Expression{Op: ExpressionAnd, Children: children}, nil
+}
+
+func (p *expressionParser) parseNot() (*Expression, error) {
+	token, ok := p.peek()
+	if ok && token == expressionTokenNot {
+		p.pos++
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &Expression{Op: ExpressionNot, Children: []*Expression{child}}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (*Expression, error) {
+	token, ok := p.peek()
+	if !ok {
+		return nil, errors.New("pattern is expected at the end of expression")
+	}
+	p.pos++
+
+	switch token {
+	case expressionTokenOpen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if token, ok := p.peek(); !ok || token != expressionTokenClose {
+			return nil, errors.New("missing ')' in expression")
+		}
+		p.pos++
+		return expr, nil
+	case expressionTokenPattern:
+		token, ok = p.peek()
+		if !ok {
+			return nil, errors.New("pattern is expected after -e")
+		}
+		p.pos++
+	case expressionTokenAnd, expressionTokenOr, expressionTokenNot, expressionTokenClose:
+		return nil, fmt.Errorf("pattern is expected before %q", token)
+	default:
+		if strings.HasPrefix(token, "-") {
+			return nil, fmt.Errorf("unknown operator %q in expression", token)
+		}
+	}
+
+	p.patterns = append(p.patterns, token)
+	return &Expression{Op: ExpressionPattern, PatternIndex: len(p.patterns) - 1}, nil
+}
+
+// markPositivePatterns sets true to indexes of patterns which are not under
+// ExpressionNot.
+func (e *Expression) markPositivePatterns(marks []bool) {
+	switch e.Op {
+	case ExpressionPattern:
+		marks[e.PatternIndex] = true
+	case ExpressionAnd, ExpressionOr:
+		for _, child := range e.Children {
+			child.markPositivePatterns(marks)
+		}
+	}
+}
+
+// NewExpressionMatcher creates a Matcher which evaluates expr. Each pattern
+// in params.Patterns is matched by a Matcher created by NewMatcher with the
+// other fields of params.
+func NewExpressionMatcher(expr *Expression, params *MatcherParams) Matcher {
+	return newExpressionMatcher(expr, params)
+}
+
+func newExpressionMatcher(expr *Expression, params *MatcherParams) (m *expressionMatcher) {
+	m = new(expressionMatcher)
+	m.op = expr.Op
+
+	if expr.Op == ExpressionPattern {
+		patternParams := *params
+		patternParams.Patterns = params.Patterns[expr.PatternIndex : expr.PatternIndex+1]
+		if params.IgnoreCases != nil {
+			patternParams.IgnoreCases = params.IgnoreCases[expr.PatternIndex : expr.PatternIndex+1]
+		}
+		m.patternIndex = expr.PatternIndex
+		m.matcher = NewMatcher(&patternParams)
+		return
+	}
+
+	m.children = make([]*expressionMatcher, len(expr.Children))
+	for i, child := range expr.Children {
+		m.children[i] = newExpressionMatcher(child, params)
+	}
+	return
+}
+
+func (m *expressionMatcher) Match(textBytes []byte) bool {
+	switch m.op {
+	case ExpressionAnd:
+		for _, child := range m.children {
+			if !child.Match(textBytes) {
+				return false
+			}
+		}
+		return true
+	case ExpressionOr:
+		for _, child := range m.children {
+			if child.Match(textBytes) {
+				return true
+			}
+		}
+		return false
+	case ExpressionNot:
+		return !m.children[0].Match(textBytes)
+	default:
+		return m.matcher.Match(textBytes)
+	}
+}
+
+// MatchSpans returns spans of patterns which make the expression true.
+// Patterns under --not don't have spans.
+func (m *expressionMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	if !m.Match(textBytes) {
+		return nil
+	}
+	spans := m.appendSpans(nil, textBytes)
+	sort.Stable(matchSpans(spans))
+	return spans
+}
+
+func (m *expressionMatcher) appendSpans(spans []MatchSpan, textBytes []byte) []MatchSpan {
+	switch m.op {
+	case ExpressionAnd, ExpressionOr:
+		for _, child := range m.children {
+			if child.op != ExpressionNot && child.Match(textBytes) {
+				spans = child.appendSpans(spans, textBytes)
+			}
+		}
+	case ExpressionPattern:
+		for _, span := range m.matcher.MatchSpans(textBytes) {
+			span.PatternIndex = m.patternIndex
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
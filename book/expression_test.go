@@ -0,0 +1,82 @@
+package book
+
+import (
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	expr, patterns, err := ParseExpression("foo --and --not ( bar --or -e -baz ) hoge")
+	if err != nil {
+		t.Fatal("ParseExpression returns an error.", err)
+	}
+	if len(patterns) != 4 || patterns[0] != "foo" || patterns[1] != "bar" || patterns[2] != "-baz" || patterns[3] != "hoge" {
+		t.Error("ParseExpression returns wrong patterns.", patterns)
+	}
+	if expr.Op != ExpressionOr || len(expr.Children) != 2 {
+		t.Fatal("Patterns without operator should be combined by or.")
+	}
+	and := expr.Children[0]
+	if and.Op != ExpressionAnd || len(and.Children) != 2 || and.Children[0].PatternIndex != 0 {
+		t.Fatal("--and should bind tighter than --or.")
+	}
+	not := and.Children[1]
+	if not.Op != ExpressionNot || not.Children[0].Op != ExpressionOr || len(not.Children[0].Children) != 2 {
+		t.Error("--not should apply to a parenthesized expression.")
+	}
+	if expr.Children[1].Op != ExpressionPattern || expr.Children[1].PatternIndex != 3 {
+		t.Error("The last pattern is not parsed.")
+	}
+}
+
+func TestParseExpressionQuote(t *testing.T) {
+	_, patterns, err := ParseExpression(`'foo bar' --or "(baz)" --or a\ b`)
+	if err != nil {
+		t.Fatal("ParseExpression returns an error.", err)
+	}
+	if len(patterns) != 3 || patterns[0] != "foo bar" || patterns[1] != "(baz)" || patterns[2] != "a b" {
+		t.Error("ParseExpression should handle quoted patterns.", patterns)
+	}
+}
+
+func TestParseExpressionError(t *testing.T) {
+	for _, text := range []string{"", "foo --and", "( foo", "foo )", "--or foo", "-e", "foo --xor bar", "'foo"} {
+		if _, _, err := ParseExpression(text); err == nil {
+			t.Error("ParseExpression should return an error.", text)
+		}
+	}
+}
+
+func TestExpressionMatcherMatch(t *testing.T) {
+	expr, patterns, _ := ParseExpression("foo --and --not ( bar --or baz )")
+	m := NewExpressionMatcher(expr, &MatcherParams{Patterns: patterns})
+	if !m.Match([]byte("foo")) || !m.Match([]byte("foo hoge")) {
+		t.Error("Match for expression doesn't work.")
+	}
+	if m.Match([]byte("foo bar")) || m.Match([]byte("foo baz")) || m.Match([]byte("hoge")) {
+		t.Error("Match for expression shouldn't match.")
+	}
+}
+
+func TestExpressionMatcherMatchParams(t *testing.T) {
+	expr, patterns, _ := ParseExpression("Foo --and Bar")
+	m := NewExpressionMatcher(expr, &MatcherParams{
+		Patterns: patterns, IgnoreCases: []bool{true, false}, FixedStrings: true, WordRegexp: true})
+	if !m.Match([]byte("FOO Bar")) {
+		t.Error("Match for expression should use params for each pattern.")
+	}
+	if m.Match([]byte("FOO BAR")) || m.Match([]byte("FOO Barx")) {
+		t.Error("Match for expression shouldn't match.")
+	}
+}
+
+func TestExpressionMatcherMatchSpans(t *testing.T) {
+	expr, patterns, _ := ParseExpression("( foo --or hoge ) --and --not bar --and b")
+	m := NewExpressionMatcher(expr, &MatcherParams{Patterns: patterns})
+	spans := m.MatchSpans([]byte("b foo"))
+	if len(spans) != 2 || spans[0] != (MatchSpan{0, 1, 3}) || spans[1] != (MatchSpan{2, 5, 0}) {
+		t.Error("MatchSpans for expression returns wrong spans.", spans)
+	}
+	if m.MatchSpans([]byte("b foo bar")) != nil {
+		t.Error("MatchSpans should return nil when the expression is false.")
+	}
+}
@@ -0,0 +1,241 @@
+package book
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldParams splits lines into fields and applies patterns to selected
+// fields only.
+type FieldParams struct {
+	// Delimiter separates fields. A field can be quoted with '"' by CSV
+	// rules, and a doubled quote in it is a quote. Each line is split by
+	// itself, so a quoted field can't have a newline. A field whose quote
+	// isn't closed in the line ends at the end of the line, and the next
+	// line starts new fields.
+	Delimiter byte
+	// Columns selects fields by 1-based indexes like "2" or by names in the
+	// header line. All fields are selected if it is empty.
+	Columns []string
+	// Header makes the first line a header. The header line is not
+	// selected. It is implied when Columns has a name.
+	Header bool
+}
+
+// field is a field in a line. value is the field without quotes, and
+// offsets[i] is the offset of value[i] in the line. offsets is nil if value
+// is a slice of the line from start.
+type field struct {
+	start   int
+	end     int
+	value   []byte
+	offsets []int
+}
+
+// Matcher which can report names of fields which match.
+type fieldNamesMatcher interface {
+	matchedFields(textBytes []byte) []string
+}
+
+// fieldMatcher applies matcher to each selected field of a line.
+type fieldMatcher struct {
+	matcher   Matcher
+	delimiter byte
+	columns   []string
+	// indexes of selected fields in ascending order. nil selects all fields.
+	indexes []int
+	names   []string
+}
+
+// ParseColumns parses a comma separated list of columns for
+// FieldParams.Columns.
+func ParseColumns(spec string) ([]string, error) {
+	columns := strings.Split(spec, ",")
+	for i, column := range columns {
+		columns[i] = strings.TrimSpace(column)
+		if n, err := strconv.Atoi(columns[i]); columns[i] == "" || (err == nil && n < 1) {
+			return nil, fmt.Errorf("invalid column %q", column)
+		}
+	}
+	return columns, nil
+}
+
+func (params *FieldParams) hasHeader() bool {
+	if params.Header {
+		return true
+	}
+	for _, column := range params.Columns {
+		if _, err := strconv.Atoi(column); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func newFieldMatcher(m Matcher, params *FieldParams) (fm *fieldMatcher) {
+	fm = new(fieldMatcher)
+	fm.matcher = m
+	fm.delimiter = params.Delimiter
+	fm.columns = params.Columns
+	if !params.hasHeader() {
+		fm.setHeader(nil)
+	}
+	return
+}
+
+// setHeader resolves columns with the header line. A name which isn't in
+// the header selects nothing.
+func (m *fieldMatcher) setHeader(header []byte) {
+	m.names = nil
+	if header != nil {
+		for _, f := range splitFields(header, m.delimiter) {
+			m.names = append(m.names, string(f.value))
+		}
+	}
+	if len(m.columns) == 0 {
+		m.indexes = nil
+		return
+	}
+
+	m.indexes = make([]int, 0, len(m.columns))
+	for _, column := range m.columns {
+		if n, err := strconv.Atoi(column); err == nil {
+			m.indexes = append(m.indexes, n-1)
+			continue
+		}
+		for i, name := range m.names {
+			if name == column {
+				m.indexes = append(m.indexes, i)
+				break
+			}
+		}
+	}
+	sort.Ints(m.indexes)
+}
+
+// selectedFields returns selected fields of textBytes with their indexes.
+func (m *fieldMatcher) selectedFields(textBytes []byte) ([]field, []int) {
+	fields := splitFields(textBytes, m.delimiter)
+	if m.indexes == nil {
+		indexes := make([]int, len(fields))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return fields, indexes
+	}
+
+	selected := make([]field, 0, len(m.indexes))
+	indexes := make([]int, 0, len(m.indexes))
+	for i, index := range m.indexes {
+		if index < len(fields) && (i == 0 || m.indexes[i-1] != index) {
+			selected = append(selected, fields[index])
+			indexes = append(indexes, index)
+		}
+	}
+	return selected, indexes
+}
+
+func (m *fieldMatcher) Match(textBytes []byte) bool {
+	fields, _ := m.selectedFields(textBytes)
+	for _, f := range fields {
+		if m.matcher.Match(f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *fieldMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	var spans []MatchSpan
+	fields, _ := m.selectedFields(textBytes)
+	for _, f := range fields {
+		for _, span := range m.matcher.MatchSpans(f.value) {
+			spans = append(spans, MatchSpan{
+				Start:        f.lineOffset(span.Start, false),
+				End:          f.lineOffset(span.End, true),
+				PatternIndex: span.PatternIndex,
+			})
+		}
+	}
+	return spans
+}
+
+// matchedFields returns names of selected fields which match. A field is
+// named by its 1-based index without a header.
+func (m *fieldMatcher) matchedFields(textBytes []byte) []string {
+	var names []string
+	fields, indexes := m.selectedFields(textBytes)
+	for i, f := range fields {
+		if !m.matcher.Match(f.value) {
+			continue
+		}
+		if indexes[i] < len(m.names) {
+			names = append(names, m.names[indexes[i]])
+		} else {
+			names = append(names, strconv.Itoa(indexes[i]+1))
+		}
+	}
+	return names
+}
+
+// lineOffset converts an offset of value to an offset of the line.
+func (f *field) lineOffset(pos int, end bool) int {
+	if f.offsets == nil {
+		return f.start + pos
+	}
+	if end && pos > 0 {
+		return f.offsets[pos-1] + 1
+	}
+	if pos < len(f.offsets) {
+		return f.offsets[pos]
+	}
+	return f.end
+}
+
+// splitFields splits textBytes by delimiter with CSV quoting rules. Text
+// after a closing quote is kept in the field as it is.
+func splitFields(textBytes []byte, delimiter byte) []field {
+	var fields []field
+	i := 0
+	for {
+		f := field{start: i}
+		if i < len(textBytes) && textBytes[i] == '"' {
+			f.value = []byte{}
+			f.offsets = []int{}
+			j := i + 1
+			for j < len(textBytes) {
+				if textBytes[j] == '"' {
+					if j+1 < len(textBytes) && textBytes[j+1] == '"' {
+						j++
+					} else {
+						j++
+						break
+					}
+				}
+				f.value = append(f.value, textBytes[j])
+				f.offsets = append(f.offsets, j)
+				j++
+			}
+			for j < len(textBytes) && textBytes[j] != delimiter {
+				f.value = append(f.value, textBytes[j])
+				f.offsets = append(f.offsets, j)
+				j++
+			}
+			f.end = j
+		} else {
+			f.end = len(textBytes)
+			if n := bytes.IndexByte(textBytes[i:], delimiter); n >= 0 {
+				f.end = i + n
+			}
+			f.value = textBytes[i:f.end]
+		}
+		fields = append(fields, f)
+		if f.end >= len(textBytes) {
+			return fields
+		}
+		i = f.end + 1
+	}
+}
@@ -0,0 +1,93 @@
+package book
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitFields(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{`a,b,c`, []string{"a", "b", "c"}},
+		{`a,,`, []string{"a", "", ""}},
+		{``, []string{""}},
+		{`"a,b",c`, []string{"a,b", "c"}},
+		{`"say ""hi""",x`, []string{`say "hi"`, "x"}},
+		{`"a"b,c`, []string{"ab", "c"}},
+		// A quoted field can't have a newline, so it ends with the line.
+		{`"open,c`, []string{"open,c"}},
+	}
+	for _, test := range tests {
+		fields := splitFields([]byte(test.text), ',')
+		values := make([]string, len(fields))
+		for i, f := range fields {
+			values[i] = string(f.value)
+		}
+		if strings.Join(values, "|") != strings.Join(test.expected, "|") {
+			t.Error("splitFields returned unexpected fields.", test.text, values)
+		}
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns("2, status")
+	if err != nil || len(columns) != 2 || columns[0] != "2" || columns[1] != "status" {
+		t.Error("ParseColumns returned unexpected columns.", columns, err)
+	}
+	for _, spec := range []string{"", "0", "1,,2"} {
+		if _, err := ParseColumns(spec); err == nil {
+			t.Error("Invalid columns should be an error.", spec)
+		}
+	}
+}
+
+func TestFieldMatcher(t *testing.T) {
+	m := newFieldMatcher(NewMatcher(&MatcherParams{Patterns: []string{"b"}}), &FieldParams{Delimiter: ',', Columns: []string{"2"}})
+	if !m.Match([]byte("x,abc,y")) || m.Match([]byte("b,x,b")) || m.Match([]byte("b")) {
+		t.Error("fieldMatcher should match selected fields only.")
+	}
+	spans := m.MatchSpans([]byte(`b,"a""b"`))
+	if len(spans) != 1 || spans[0] != (MatchSpan{6, 7, 0}) {
+		t.Error("MatchSpans should return offsets in the line.", spans)
+	}
+	if names := m.matchedFields([]byte("x,b")); len(names) != 1 || names[0] != "2" {
+		t.Error("A field should be named by its index without a header.", names)
+	}
+
+	m = newFieldMatcher(NewMatcher(&MatcherParams{Patterns: []string{"5"}}), &FieldParams{Delimiter: '\t', Columns: []string{"status", "missing"}})
+	m.setHeader([]byte("path\tstatus\tsize"))
+	if !m.Match([]byte("/5\t500\t1")) || m.Match([]byte("/5\t200\t5")) {
+		t.Error("fieldMatcher should select fields by header names.")
+	}
+	if names := m.matchedFields([]byte("/\t503\t1")); len(names) != 1 || names[0] != "status" {
+		t.Error("A field should be named by the header.", names)
+	}
+}
+
+func TestFindFields(t *testing.T) {
+	c := newChapterBytes([]byte("name,status\nerror,200\nok,500\n"))
+	defer c.Close()
+	var lineNumbers []LineNumber
+	var fields []string
+	count := c.Find(&FindParams{
+		Patterns: []string{"error|500"},
+		Fields:   &FieldParams{Delimiter: ',', Columns: []string{"status"}},
+		Handler: func(params *FoundParams) {
+			lineNumbers = append(lineNumbers, params.lineNumber)
+			fields = append(fields, params.MatchedFields()...)
+		},
+	})
+	if count != 1 || len(lineNumbers) != 1 || lineNumbers[0] != 3 {
+		t.Error("Find should select lines by fields and skip the header.", count, lineNumbers)
+	}
+	if len(fields) != 1 || fields[0] != "status" {
+		t.Error("MatchedFields should return the matched field.", fields)
+	}
+
+	count = c.Find(&FindParams{Patterns: []string{"status"}, Fields: &FieldParams{Delimiter: ',', Header: true}})
+	if count != 0 {
+		t.Error("Header line shouldn't be selected.", count)
+	}
+}
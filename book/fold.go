@@ -0,0 +1,119 @@
+package book
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// foldPattern is a pattern for case insensitive search. Runes are compared
+// by their case folded forms, so upper and lower case letters can have
+// different lengths in UTF-8 (e.g. 'k' and KELVIN SIGN).
+type foldPattern struct {
+	runes []rune
+	// KMP failure table of runes.
+	failure []int
+}
+
+func newFoldPattern(pattern []byte) *foldPattern {
+	p := new(foldPattern)
+	p.runes = make([]rune, 0, len(pattern))
+	for i := 0; i < len(pattern); {
+		r, size := decodeFoldRune(pattern[i:])
+		p.runes = append(p.runes, r)
+		i += size
+	}
+
+	p.failure = make([]int, len(p.runes))
+	k := 0
+	for i := 1; i < len(p.runes); i++ {
+		for k > 0 && p.runes[i] != p.runes[k] {
+			k = p.failure[k-1]
+		}
+		if p.runes[i] == p.runes[k] {
+			k++
+		}
+		p.failure[i] = k
+	}
+	return p
+}
+
+// index returns the byte range of the first instance of the pattern in
+// textBytes, or -1, -1 if it is not present. It is linear in the length of
+// textBytes.
+func (p *foldPattern) index(textBytes []byte) (int, int) {
+	patternLen := len(p.runes)
+	if patternLen == 0 {
+		return 0, 0
+	}
+
+	matched := 0
+	for i := 0; i < len(textBytes); {
+		r, size := decodeFoldRune(textBytes[i:])
+		i += size
+		for matched > 0 && r != p.runes[matched] {
+			matched = p.failure[matched-1]
+		}
+		if r == p.runes[matched] {
+			matched++
+			if matched == patternLen {
+				start := i
+				for n := 0; n < patternLen; n++ {
+					_, size := utf8.DecodeLastRune(textBytes[:start])
+					start -= size
+				}
+				return start, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// foldBytes returns textBytes whose runes are replaced by their folded
+// forms. Invalid bytes are kept. Folded text contains a folded pattern when
+// the text contains the pattern ignoring case.
+func foldBytes(textBytes []byte) []byte {
+	folded := make([]byte, 0, len(textBytes))
+	var buffer [utf8.UTFMax]byte
+	for i := 0; i < len(textBytes); {
+		r, size := decodeFoldRune(textBytes[i:])
+		if r < 0 {
+			folded = append(folded, textBytes[i])
+		} else {
+			n := utf8.EncodeRune(buffer[:], r)
+			folded = append(folded, buffer[:n]...)
+		}
+		i += size
+	}
+	return folded
+}
+
+// decodeFoldRune decodes the first rune in textBytes and returns its folded
+// form. Each invalid byte is mapped to its own negative value so that it
+// only matches the same byte.
+func decodeFoldRune(textBytes []byte) (rune, int) {
+	b := textBytes[0]
+	if b < utf8.RuneSelf {
+		if 'a' <= b && b <= 'z' {
+			b -= 'a' - 'A'
+		}
+		return rune(b), 1
+	}
+
+	r, size := utf8.DecodeRune(textBytes)
+	if r == utf8.RuneError && size == 1 {
+		return -rune(b), 1
+	}
+	return foldRune(r), size
+}
+
+// foldRune returns the smallest rune in the case folding orbit of r. Runes
+// which are equal ignoring case have the same folded rune.
+func foldRune(r rune) rune {
+	folded := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < folded {
+			folded = f
+		}
+	}
+	return folded
+}
@@ -0,0 +1,75 @@
+package book
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFoldPatternIndex(t *testing.T) {
+	p := newFoldPattern([]byte("foo"))
+	if start, end := p.index([]byte("xFoO")); start != 1 || end != 4 {
+		t.Error("index should find text ignoring case.", start, end)
+	}
+	if start, end := p.index([]byte("fofo")); start != -1 || end != -1 {
+		t.Error("index shouldn't find text.", start, end)
+	}
+	if start, end := newFoldPattern([]byte("")).index([]byte("foo")); start != 0 || end != 0 {
+		t.Error("Empty pattern should match at the beginning.", start, end)
+	}
+}
+
+func TestFoldPatternIndexRestart(t *testing.T) {
+	p := newFoldPattern([]byte("aab"))
+	if start, end := p.index([]byte("AAAAB")); start != 2 || end != 5 {
+		t.Error("index should retry from a partial match.", start, end)
+	}
+}
+
+func TestFoldPatternIndexPartialMatches(t *testing.T) {
+	p := newFoldPattern([]byte("foo"))
+	for _, text := range []string{"foo", "Foo", "FOO", "xFoOy", "FoFoOy", "FoFoO"} {
+		start, end := p.index([]byte(text))
+		if start < 0 || !strings.EqualFold(text[start:end], "foo") {
+			t.Error("index should find text.", text, start, end)
+		}
+	}
+	for _, text := range []string{"", "F", "Fo", "FoF", "FoFo"} {
+		if start, end := p.index([]byte(text)); start != -1 || end != -1 {
+			t.Error("index shouldn't find text.", text, start, end)
+		}
+	}
+}
+
+func TestFoldPatternIndexUnicode(t *testing.T) {
+	// KELVIN SIGN(3 bytes) and LATIN SMALL LETTER LONG S(2 bytes) fold to ASCII.
+	p := newFoldPattern([]byte("kiss"))
+	if start, end := p.index([]byte("a KIſS")); start != 2 || end != 9 {
+		t.Error("index should find runes which have different lengths.", start, end)
+	}
+	p = newFoldPattern([]byte("ΣΊΣΥΦΟΣ"))
+	if start, end := p.index([]byte("ο σίσυφος")); start != 3 || end != 17 {
+		t.Error("index should find Greek text ignoring case.", start, end)
+	}
+	p = newFoldPattern([]byte("ｇｏ"))
+	if start, _ := p.index([]byte("ＧＯ")); start != 0 {
+		t.Error("index should find full-width text ignoring case.", start)
+	}
+}
+
+func TestFoldPatternIndexInvalidUTF8(t *testing.T) {
+	p := newFoldPattern([]byte("a\xffb"))
+	if start, _ := p.index([]byte("a\xfeb")); start != -1 {
+		t.Error("Different invalid bytes shouldn't match.")
+	}
+	if start, _ := p.index([]byte("xA\xffB")); start != 1 {
+		t.Error("Same invalid bytes should match.")
+	}
+}
+
+func TestFoldPatternIndexLongText(t *testing.T) {
+	text := []byte(strings.Repeat("a", 100000) + "B")
+	p := newFoldPattern([]byte(strings.Repeat("A", 1000) + "b"))
+	if start, end := p.index(text); start != 99000 || end != 100001 {
+		t.Error("index should find a pattern in long text.", start, end)
+	}
+}
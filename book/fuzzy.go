@@ -0,0 +1,249 @@
+package book
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	// Patterns up to this length are searched by bit-parallel algorithm.
+	fuzzyBitParallelMaxLength = 64
+)
+
+// Matcher which can report the edit distance of a match.
+type distanceMatcher interface {
+	// Distance returns the smallest edit distance between patterns and
+	// substrings of bytes, or -1 if nothing matches.
+	Distance(bytes []byte) int
+}
+
+// fuzzyMatcher matches fixed string patterns approximately. A line matches
+// if it contains a string within maxErrors insertions, deletions and
+// substitutions of runes from a pattern.
+type fuzzyMatcher struct {
+	matcher
+	maxErrors     int
+	fuzzyPatterns []*fuzzyPattern
+}
+
+type fuzzyPattern struct {
+	runes      []rune
+	ignoreCase bool
+	// Bit masks of positions in runes for bit-parallel search.
+	asciiMasks [utf8.RuneSelf]uint64
+	masks      map[rune]uint64
+}
+
+type fuzzyCandidate struct {
+	start    int
+	end      int
+	distance int
+}
+
+func newFuzzyMatcher(params *MatcherParams) (m *fuzzyMatcher) {
+	m = new(fuzzyMatcher)
+	m.patterns = params.Patterns
+	m.ignoreCase = params.IgnoreCase
+	m.ignoreCases = params.IgnoreCases
+	m.maxErrors = params.MaxErrors
+
+	m.fuzzyPatterns = make([]*fuzzyPattern, len(m.patterns))
+	for i, p := range m.patterns {
+		m.fuzzyPatterns[i] = newFuzzyPattern([]byte(p), m.isIgnoreCase(i))
+	}
+	return
+}
+
+func (m *fuzzyMatcher) Match(textBytes []byte) bool {
+	for _, p := range m.fuzzyPatterns {
+		if p.distance(textBytes, m.maxErrors) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *fuzzyMatcher) Distance(textBytes []byte) int {
+	distance := -1
+	for _, p := range m.fuzzyPatterns {
+		maxErrors := m.maxErrors
+		if distance >= 0 {
+			maxErrors = distance - 1
+		}
+		if d := p.distance(textBytes, maxErrors); d >= 0 {
+			distance = d
+		}
+	}
+	return distance
+}
+
+func (m *fuzzyMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	var spans []MatchSpan
+	for i, p := range m.fuzzyPatterns {
+		for _, candidate := range p.candidates(textBytes, m.maxErrors) {
+			spans = append(spans, MatchSpan{Start: candidate.start, End: candidate.end, PatternIndex: i})
+		}
+	}
+	sort.Stable(matchSpans(spans))
+	return spans
+}
+
+func newFuzzyPattern(pattern []byte, ignoreCase bool) *fuzzyPattern {
+	p := new(fuzzyPattern)
+	p.ignoreCase = ignoreCase
+	p.runes = make([]rune, 0, len(pattern))
+	for i := 0; i < len(pattern); {
+		r, size := p.decodeRune(pattern[i:])
+		p.runes = append(p.runes, r)
+		i += size
+	}
+
+	if len(p.runes) <= fuzzyBitParallelMaxLength {
+		p.masks = make(map[rune]uint64)
+		for i, r := range p.runes {
+			if 0 <= r && r < utf8.RuneSelf {
+				p.asciiMasks[r] |= 1 << uint(i)
+			} else {
+				p.masks[r] |= 1 << uint(i)
+			}
+		}
+	}
+	return p
+}
+
+// decodeRune is like decodeFoldRune, but it doesn't fold case unless
+// ignoreCase is set.
+func (p *fuzzyPattern) decodeRune(textBytes []byte) (rune, int) {
+	if p.ignoreCase {
+		return decodeFoldRune(textBytes)
+	}
+	r, size := utf8.DecodeRune(textBytes)
+	if r == utf8.RuneError && size == 1 {
+		return -rune(textBytes[0]), 1
+	}
+	return r, size
+}
+
+func (p *fuzzyPattern) mask(r rune) uint64 {
+	if 0 <= r && r < utf8.RuneSelf {
+		return p.asciiMasks[r]
+	}
+	return p.masks[r]
+}
+
+// distance returns the smallest edit distance between the pattern and
+// substrings of textBytes if it is not larger than maxErrors. Otherwise, it
+// returns -1.
+func (p *fuzzyPattern) distance(textBytes []byte, maxErrors int) int {
+	if maxErrors < 0 {
+		return -1
+	}
+
+	patternLen := len(p.runes)
+	if patternLen > fuzzyBitParallelMaxLength {
+		distance := -1
+		for _, candidate := range p.candidates(textBytes, maxErrors) {
+			if distance < 0 || candidate.distance < distance {
+				distance = candidate.distance
+			}
+		}
+		if patternLen <= maxErrors && (distance < 0 || patternLen < distance) {
+			distance = patternLen
+		}
+		return distance
+	}
+
+	// Wu-Manber bit-parallel algorithm. A bit i of states[d] is set when
+	// the pattern prefix of length i+1 matches a suffix of the text read so
+	// far with at most d errors.
+	distance := -1
+	if patternLen <= maxErrors {
+		distance = patternLen
+	}
+	if patternLen == 0 {
+		return distance
+	}
+	final := uint64(1) << uint(patternLen-1)
+	states := make([]uint64, maxErrors+1)
+	for d := range states {
+		states[d] = (1 << uint(d)) - 1
+	}
+
+	for i := 0; i < len(textBytes) && distance != 0; {
+		r, size := p.decodeRune(textBytes[i:])
+		i += size
+		b := p.mask(r)
+
+		previous := states[0]
+		states[0] = ((states[0] << 1) | 1) & b
+		if states[0]&final != 0 {
+			return 0
+		}
+		for d := 1; d < len(states); d++ {
+			old := states[d]
+			states[d] = (((old << 1) | 1) & b) | // match
+				((previous << 1) | 1) | // substitution
+				((states[d-1] << 1) | 1) | // deletion
+				previous // insertion
+			previous = old
+			if states[d]&final != 0 && (distance < 0 || d < distance) {
+				distance = d
+			}
+		}
+	}
+	return distance
+}
+
+// candidates returns non-overlapping matches of the pattern in textBytes
+// within maxErrors. It uses Sellers' dynamic programming and tracks start
+// positions of matches. Empty matches are not returned.
+func (p *fuzzyPattern) candidates(textBytes []byte, maxErrors int) []fuzzyCandidate {
+	patternLen := len(p.runes)
+	costs := make([]int, patternLen+1)
+	starts := make([]int, patternLen+1)
+	nextCosts := make([]int, patternLen+1)
+	nextStarts := make([]int, patternLen+1)
+	for j := range costs {
+		costs[j] = j
+	}
+
+	var result []fuzzyCandidate
+	for i := 0; i < len(textBytes); {
+		r, size := p.decodeRune(textBytes[i:])
+		i += size
+
+		nextCosts[0] = 0
+		nextStarts[0] = i
+		for j := 1; j <= patternLen; j++ {
+			cost, start := costs[j-1], starts[j-1]
+			if p.runes[j-1] != r {
+				cost++
+			}
+			if nextCosts[j-1]+1 < cost {
+				cost, start = nextCosts[j-1]+1, nextStarts[j-1]
+			}
+			if costs[j]+1 < cost {
+				cost, start = costs[j]+1, starts[j]
+			}
+			nextCosts[j], nextStarts[j] = cost, start
+		}
+		costs, nextCosts = nextCosts, costs
+		starts, nextStarts = nextStarts, starts
+
+		if costs[patternLen] > maxErrors || starts[patternLen] == i {
+			continue
+		}
+		candidate := fuzzyCandidate{start: starts[patternLen], end: i, distance: costs[patternLen]}
+		n := len(result)
+		if n > 0 && candidate.start < result[n-1].end {
+			// Prefer the nearer match, or the longer one from the same start.
+			if candidate.distance < result[n-1].distance ||
+				(candidate.distance == result[n-1].distance && candidate.start == result[n-1].start) {
+				result[n-1] = candidate
+			}
+		} else {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
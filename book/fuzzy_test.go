@@ -0,0 +1,87 @@
+package book
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFuzzyPatternDistance(t *testing.T) {
+	p := newFuzzyPattern([]byte("survey"), false)
+	cases := []struct {
+		text     string
+		distance int
+	}{
+		{"a survey of", 0},
+		{"surgery", 2},
+		{"a servey", 1},
+		{"survy", 1},
+		{"surveey", 1},
+		{"SURVEY", -1},
+		{"xyz", -1},
+	}
+	for _, c := range cases {
+		if d := p.distance([]byte(c.text), 2); d != c.distance {
+			t.Error("distance returns a wrong value.", c.text, d, c.distance)
+		}
+	}
+}
+
+func TestFuzzyPatternDistanceIgnoreCase(t *testing.T) {
+	p := newFuzzyPattern([]byte("Straße"), true)
+	if d := p.distance([]byte("STRAẞE"), 1); d != 0 {
+		t.Error("distance should ignore case.", d)
+	}
+	if d := p.distance([]byte("strase"), 1); d != 1 {
+		t.Error("A multi-byte rune should be a single error.", d)
+	}
+}
+
+func TestFuzzyPatternDistanceShortPattern(t *testing.T) {
+	p := newFuzzyPattern([]byte("ab"), false)
+	if d := p.distance([]byte("xyz"), 2); d != 2 {
+		t.Error("A pattern shorter than errors should match anything.", d)
+	}
+	if d := p.distance([]byte("xaz"), 2); d != 1 {
+		t.Error("distance should return the smallest distance.", d)
+	}
+}
+
+func TestFuzzyPatternDistanceLongPattern(t *testing.T) {
+	pattern := strings.Repeat("abcdefghij", 8)
+	p := newFuzzyPattern([]byte(pattern), false)
+	text := "xx" + strings.Replace(pattern, "e", "E", 2) + "yy"
+	if d := p.distance([]byte(text), 3); d != 2 {
+		t.Error("distance should work for a long pattern.", d)
+	}
+	if d := p.distance([]byte(text), 1); d != -1 {
+		t.Error("distance should return -1 for too many errors.", d)
+	}
+}
+
+func TestFuzzyPatternCandidates(t *testing.T) {
+	p := newFuzzyPattern([]byte("color"), false)
+	candidates := p.candidates([]byte("the colour and the colr"), 1)
+	if len(candidates) != 2 {
+		t.Fatal("candidates returns a wrong number of matches.", candidates)
+	}
+	if candidates[0] != (fuzzyCandidate{4, 10, 1}) || candidates[1] != (fuzzyCandidate{19, 23, 1}) {
+		t.Error("candidates returns wrong matches.", candidates)
+	}
+}
+
+func TestFuzzyMatcher(t *testing.T) {
+	m := NewMatcher(&MatcherParams{Patterns: patternsForTest("kitten", "gopher"), MaxErrors: 2})
+	if !m.Match([]byte("sitting")) || !m.Match([]byte("gofer")) {
+		t.Error("Match for fuzzy doesn't work.")
+	}
+	if m.Match([]byte("mitt")) {
+		t.Error("Match for fuzzy shouldn't match.")
+	}
+	if d := m.(distanceMatcher).Distance([]byte("a gopher and a kitten")); d != 0 {
+		t.Error("Distance returns a wrong value.", d)
+	}
+	spans := m.MatchSpans([]byte("gofer"))
+	if len(spans) != 1 || spans[0].PatternIndex != 1 {
+		t.Error("MatchSpans returns wrong spans.", spans)
+	}
+}
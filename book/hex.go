@@ -0,0 +1,197 @@
+package book
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	hexReadSize = 64 * 1024
+)
+
+// HexPattern is a byte sequence like "CA FE ?? BE". "??" matches any byte.
+type HexPattern struct {
+	text      string
+	bytes     []byte
+	wildcards []bool
+	// The longest run of bytes without wildcards is searched first.
+	anchor       []byte
+	anchorOffset int
+}
+
+// hexMatch is a match of a HexPattern at offset of the input.
+type hexMatch struct {
+	offset       int64
+	patternIndex int
+	bytes        []byte
+}
+
+// ParseHexPattern parses pairs of hex digits and "??". Spaces between
+// bytes are optional. e.g. "CAFEBABE", "ca fe ?? be"
+func ParseHexPattern(text string) (*HexPattern, error) {
+	p := &HexPattern{text: text}
+	digits := strings.Join(strings.Fields(text), "")
+	if len(digits) == 0 || len(digits)%2 != 0 {
+		return nil, fmt.Errorf("invalid hex pattern %q", text)
+	}
+	for i := 0; i < len(digits); i += 2 {
+		if digits[i:i+2] == "??" {
+			p.bytes = append(p.bytes, 0)
+			p.wildcards = append(p.wildcards, true)
+			continue
+		}
+		b, err := hex.DecodeString(digits[i : i+2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex pattern %q", text)
+		}
+		p.bytes = append(p.bytes, b[0])
+		p.wildcards = append(p.wildcards, false)
+	}
+
+	for start := 0; start < len(p.bytes); {
+		if p.wildcards[start] {
+			start++
+			continue
+		}
+		end := start
+		for end < len(p.bytes) && !p.wildcards[end] {
+			end++
+		}
+		if end-start > len(p.anchor) {
+			p.anchor, p.anchorOffset = p.bytes[start:end], start
+		}
+		start = end
+	}
+	return p, nil
+}
+
+func (p *HexPattern) String() string {
+	return p.text
+}
+
+// matchAt checks the pattern matches data at pos.
+func (p *HexPattern) matchAt(data []byte, pos int) bool {
+	if pos < 0 || pos+len(p.bytes) > len(data) {
+		return false
+	}
+	for i, b := range p.bytes {
+		if !p.wildcards[i] && data[pos+i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// indexes returns positions in data before limit where the pattern
+// matches.
+func (p *HexPattern) indexes(data []byte, limit int) []int {
+	var positions []int
+	if len(p.anchor) == 0 {
+		for pos := 0; pos < limit && pos+len(p.bytes) <= len(data); pos++ {
+			positions = append(positions, pos)
+		}
+		return positions
+	}
+	for from := p.anchorOffset; from < len(data); {
+		n := bytes.Index(data[from:], p.anchor)
+		if n < 0 {
+			break
+		}
+		pos := from + n - p.anchorOffset
+		if pos >= limit {
+			break
+		}
+		if p.matchAt(data, pos) {
+			positions = append(positions, pos)
+		}
+		from += n + 1
+	}
+	return positions
+}
+
+// findHex scans raw bytes of the input for HexPatterns. Lines aren't used,
+// so a match can have newlines. Every match is reported with its byte
+// offset, and matches can overlap.
+func (c *chapter) findHex(findParams *FindParams) (count FoundCountType) {
+	input, err := c.createInput()
+	if err != nil {
+		return
+	}
+	defer input.Close()
+
+	maxLength := 0
+	for _, p := range findParams.HexPatterns {
+		if len(p.bytes) > maxLength {
+			maxLength = len(p.bytes)
+		}
+	}
+
+	foundParams := FoundParams{FindParams: *findParams, file: c.file}
+	var data []byte
+	var base int64
+	buffer := make([]byte, hexReadSize)
+	for eof := false; !eof; {
+		n, err := io.ReadFull(input, buffer)
+		data = append(data, buffer[:n]...)
+		eof = err != nil
+		// A match which starts after limit can continue in the next read.
+		limit := len(data) - maxLength + 1
+		if eof {
+			limit = len(data)
+		}
+		if limit <= 0 {
+			continue
+		}
+
+		var matches []hexMatch
+		for i, p := range findParams.HexPatterns {
+			for _, pos := range p.indexes(data, limit) {
+				matches = append(matches, hexMatch{offset: base + int64(pos), patternIndex: i, bytes: data[pos : pos+len(p.bytes)]})
+			}
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].offset < matches[j].offset
+		})
+		for _, m := range matches {
+			count++
+			if findParams.Handler != nil {
+				foundParams.hexMatch = m
+				findParams.Handler(&foundParams)
+			}
+			if findParams.StopOnFirstMatch || (findParams.MaxCount > 0 && count >= FoundCountType(findParams.MaxCount)) {
+				return
+			}
+		}
+
+		data = append(data[:0], data[limit:]...)
+		base += int64(limit)
+	}
+	return
+}
+
+// ByteOffset returns the offset of the found byte sequence in the input
+// when HexPatterns is set.
+func (params *FoundParams) ByteOffset() int64 {
+	return params.hexMatch.offset
+}
+
+// HexFoundHandler prints byte offsets and bytes of found byte sequences.
+func HexFoundHandler(params *FoundParams) {
+	params.println(params.hexMatch.offset, ": ", hexBytes(params.hexMatch.bytes))
+}
+
+func FileNameHexFoundHandler(params *FoundParams) {
+	params.println(params.file, ": ", params.hexMatch.offset, ": ", hexBytes(params.hexMatch.bytes))
+}
+
+func hexBytes(data []byte) string {
+	texts := make([]string, len(data))
+	for i, b := range data {
+		texts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(texts, " ")
+}
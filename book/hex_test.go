@@ -0,0 +1,68 @@
+package book
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseHexPattern(t *testing.T) {
+	p, err := ParseHexPattern("ca ?? BE EF 01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.anchor, []byte{0xbe, 0xef, 0x01}) || p.anchorOffset != 2 || !p.wildcards[1] {
+		t.Error("ParseHexPattern returned an unexpected pattern.", p)
+	}
+	if p, err := ParseHexPattern("CAFEBABE"); err != nil || len(p.bytes) != 4 {
+		t.Error("Spaces should be optional.", err)
+	}
+	for _, text := range []string{"", "CAF", "CA FG", "?"} {
+		if _, err := ParseHexPattern(text); err == nil {
+			t.Error("Invalid hex pattern should be an error.", text)
+		}
+	}
+}
+
+func TestHexPatternIndexes(t *testing.T) {
+	p, _ := ParseHexPattern("AA ?? AA")
+	data := []byte{0xaa, 0x00, 0xaa, 0x01, 0xaa, 0xaa}
+	if positions := p.indexes(data, len(data)); len(positions) != 2 || positions[0] != 0 || positions[1] != 2 {
+		t.Error("indexes should return overlapping matches.", positions)
+	}
+	if positions := p.indexes(data, 2); len(positions) != 1 {
+		t.Error("indexes shouldn't return positions after limit.", positions)
+	}
+	p, _ = ParseHexPattern("?? ??")
+	if positions := p.indexes(data, len(data)); len(positions) != 5 {
+		t.Error("Wildcards should match any bytes.", positions)
+	}
+}
+
+func TestFindHex(t *testing.T) {
+	data := make([]byte, 3*hexReadSize)
+	copy(data[10:], []byte{0xca, 0xfe, 0x0a, 0xbe})
+	// A match over the boundary of reads.
+	copy(data[hexReadSize-2:], []byte{0xca, 0xfe, 0x00, 0xbe})
+	copy(data[len(data)-4:], []byte{0xca, 0xfe, 0xba, 0xbe})
+	c := newChapterBytes(data)
+	defer c.Close()
+
+	p, _ := ParseHexPattern("CA FE ?? BE")
+	var offsets []int64
+	count := c.Find(&FindParams{
+		HexPatterns: []*HexPattern{p},
+		Handler: func(params *FoundParams) {
+			offsets = append(offsets, params.ByteOffset())
+		},
+	})
+	if count != 3 || len(offsets) != 3 || offsets[0] != 10 || offsets[1] != hexReadSize-2 || offsets[2] != int64(len(data)-4) {
+		t.Error("Find should report byte offsets of hex patterns.", count, offsets)
+	}
+
+	if count := c.Find(&FindParams{HexPatterns: []*HexPattern{p}, MaxCount: 2}); count != 2 {
+		t.Error("Find should stop after MaxCount matches.", count)
+	}
+	if count := c.Find(&FindParams{HexPatterns: []*HexPattern{p}, StopOnFirstMatch: true}); count != 1 {
+		t.Error("Find should stop at the first match.", count)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync/atomic"
 )
 
 const (
@@ -18,11 +19,17 @@ type LineBytesHandler func(text []byte)
 
 type Input interface {
 	EachLineBytes(handler LineBytesHandler) error
+	// Stop makes EachLineBytes return before reading the next line. It can
+	// be called from other goroutines.
+	Stop()
+	// Read reads raw bytes without splitting lines. e.g. HexPatterns
+	io.Reader
 	io.Closer
 }
 
 type baseInput struct {
 	fileReader *bufio.Reader
+	stopped    int32
 }
 
 type fileInput struct {
@@ -73,19 +80,31 @@ func newBytesInput(byteData []byte) (input *bytesInput, err error) {
 }
 
 func (input *baseInput) EachLineBytes(handler LineBytesHandler) error {
-	return eachLineBytes(input.fileReader, handler)
+	return eachLineBytes(input.fileReader, &input.stopped, handler)
+}
+
+func (input *baseInput) Stop() {
+	atomic.StoreInt32(&input.stopped, 1)
+}
+
+func (input *baseInput) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&input.stopped) != 0 {
+		return 0, io.EOF
+	}
+	return input.fileReader.Read(p)
 }
 
 // TODO: Need to set a limit to avoid continuing allocation. It is like
 // to skip parsing this line.
-func eachLineBytes(reader *bufio.Reader, handler LineBytesHandler) error {
-	for {
+func eachLineBytes(reader *bufio.Reader, stopped *int32, handler LineBytesHandler) error {
+	for atomic.LoadInt32(stopped) == 0 {
 		line, isPrefix, err := reader.ReadLine()
 		if isPrefix {
 			total := 0
 			n := 0
 			lineFragments := make([][]byte, defaultOverflowNewArraySize)
-			lineFragments[n] = line
+			// ReadLine overwrites its buffer by the next call.
+			lineFragments[n] = append([]byte(nil), line...)
 			total += len(line)
 			for isPrefix {
 				n++
@@ -95,7 +114,7 @@ func eachLineBytes(reader *bufio.Reader, handler LineBytesHandler) error {
 					copy(newFragments, lineFragments)
 					lineFragments = newFragments
 				}
-				lineFragments[n] = line
+				lineFragments[n] = append([]byte(nil), line...)
 				total += len(line)
 				if err != nil {
 					break
@@ -47,6 +47,16 @@ func TestEachLineBytesLong(t *testing.T) {
 	if res != string(b) {
 		t.Error("EachLineBytes doesn't read bytes.")
 	}
+
+	var lines []string
+	input, _ = NewBytesInput(append(b, "\nb\n"...))
+	input.EachLineBytes(func(text []byte) {
+		lines = append(lines, string(text))
+	})
+	input.Close()
+	if len(lines) != 2 || lines[0] != string(b) || lines[1] != "b" {
+		t.Error("EachLineBytes should read a long line before a newline.", len(lines))
+	}
 }
 
 func TestEachLineBytesTooLongAndSkipLine(t *testing.T) {
@@ -64,3 +74,18 @@ func TestEachLineBytesTooLongAndSkipLine(t *testing.T) {
 		t.Error("EachLineBytes doesn't skip bytes.")
 	}
 }
+
+func TestEachLineBytesStop(t *testing.T) {
+	input, _ := NewBytesInput([]byte("foo\nbar\nbaz\n"))
+	var lines []string
+	input.EachLineBytes(func(text []byte) {
+		lines = append(lines, string(text))
+		if len(lines) == 2 {
+			input.Stop()
+		}
+	})
+	input.Close()
+	if len(lines) != 2 {
+		t.Error("EachLineBytes should stop reading lines.", lines)
+	}
+}
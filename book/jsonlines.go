@@ -0,0 +1,390 @@
+package book
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// InvalidJSONName is the name of the field printed for a line which isn't
+// JSON when JSONParams.ReportInvalid is set.
+const InvalidJSONName = "invalid JSON"
+
+// JSONParams parses each line as JSON and applies patterns to values at
+// Paths only.
+type JSONParams struct {
+	// Paths are like ".level", ".req.path", ".tags[0]" or ".tags[]". "[]"
+	// selects every element of an array. A value which is an object or an
+	// array is matched as JSON text.
+	Paths []string
+	// ReportInvalid selects lines which aren't JSON instead of skipping
+	// them. Empty lines are always skipped. Inverted matching skips both.
+	ReportInvalid bool
+}
+
+type jsonPathElement struct {
+	key string
+	// index is -1 for a key and -2 for any element of an array.
+	index int
+}
+
+type jsonPath struct {
+	name     string
+	elements []jsonPathElement
+}
+
+// jsonValue is a value at a path in a line. value is a string without
+// quotes and escapes, or JSON text of other values. offsets[i] is the offset
+// of value[i] in the line. offsets is nil if value is a slice of the line
+// from start.
+type jsonValue struct {
+	pathIndex int
+	start     int
+	end       int
+	value     []byte
+	offsets   []int
+}
+
+// jsonFrame is an object or an array which is being read.
+type jsonFrame struct {
+	array     bool
+	expectKey bool
+	key       string
+	index     int
+	// Paths which select this object or array, and its start offset.
+	pathIndexes []int
+	start       int
+}
+
+// jsonMatcher applies matcher to values at paths of a JSON line.
+type jsonMatcher struct {
+	matcher       Matcher
+	paths         []jsonPath
+	reportInvalid bool
+}
+
+// ParseJSONPaths parses a comma separated list of paths for
+// JSONParams.Paths.
+func ParseJSONPaths(spec string) ([]string, error) {
+	paths := strings.Split(spec, ",")
+	for i, path := range paths {
+		paths[i] = strings.TrimSpace(path)
+		if _, err := parseJSONPath(paths[i]); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+func parseJSONPath(text string) (path jsonPath, err error) {
+	path.name = text
+	rest := text
+	if !strings.HasPrefix(rest, ".") && !strings.HasPrefix(rest, "[") {
+		return path, fmt.Errorf("invalid JSON path %q", text)
+	}
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			n := strings.IndexAny(rest[1:], ".[")
+			if n < 0 {
+				n = len(rest) - 1
+			}
+			if n == 0 {
+				return path, fmt.Errorf("invalid JSON path %q", text)
+			}
+			path.elements = append(path.elements, jsonPathElement{key: rest[1 : n+1], index: -1})
+			rest = rest[n+1:]
+		case '[':
+			n := strings.IndexByte(rest, ']')
+			if n < 0 {
+				return path, fmt.Errorf("invalid JSON path %q", text)
+			}
+			index := -2
+			if n > 1 {
+				if index, err = strconv.Atoi(rest[1:n]); err != nil || index < 0 {
+					return path, fmt.Errorf("invalid JSON path %q", text)
+				}
+			}
+			path.elements = append(path.elements, jsonPathElement{index: index})
+			rest = rest[n+1:]
+		default:
+			return path, fmt.Errorf("invalid JSON path %q", text)
+		}
+	}
+	return path, nil
+}
+
+func newJSONMatcher(m Matcher, params *JSONParams) (jm *jsonMatcher) {
+	jm = new(jsonMatcher)
+	jm.matcher = m
+	jm.reportInvalid = params.ReportInvalid
+	for _, p := range params.Paths {
+		// Paths are checked by ParseJSONPaths. An invalid path selects
+		// nothing.
+		path, _ := parseJSONPath(p)
+		jm.paths = append(jm.paths, path)
+	}
+	return
+}
+
+func (m *jsonMatcher) Match(textBytes []byte) bool {
+	values, ok := m.values(textBytes)
+	if !ok {
+		return m.reportInvalid
+	}
+	for _, v := range values {
+		if m.matcher.Match(v.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *jsonMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	var spans []MatchSpan
+	values, _ := m.values(textBytes)
+	for _, v := range values {
+		for _, span := range m.matcher.MatchSpans(v.value) {
+			spans = append(spans, MatchSpan{
+				Start:        v.lineOffset(span.Start),
+				End:          v.lineOffset(span.End),
+				PatternIndex: span.PatternIndex,
+			})
+		}
+	}
+	sort.Stable(matchSpans(spans))
+	return spans
+}
+
+// isJSON checks textBytes has JSON values. An empty line doesn't have them.
+func (m *jsonMatcher) isJSON(textBytes []byte) bool {
+	if len(bytes.TrimSpace(textBytes)) == 0 {
+		return false
+	}
+	_, ok := m.values(textBytes)
+	return ok
+}
+
+// matchedFields returns paths of values which match.
+func (m *jsonMatcher) matchedFields(textBytes []byte) []string {
+	values, ok := m.values(textBytes)
+	if !ok {
+		if m.reportInvalid {
+			return []string{InvalidJSONName}
+		}
+		return nil
+	}
+	found := make([]bool, len(m.paths))
+	for _, v := range values {
+		found[v.pathIndex] = found[v.pathIndex] || m.matcher.Match(v.value)
+	}
+	var names []string
+	for i, ok := range found {
+		if ok {
+			names = append(names, m.paths[i].name)
+		}
+	}
+	return names
+}
+
+// values returns values at paths in textBytes in the order of their
+// offsets. It returns false if textBytes isn't a JSON value.
+func (m *jsonMatcher) values(textBytes []byte) ([]jsonValue, bool) {
+	if len(bytes.TrimSpace(textBytes)) == 0 {
+		return nil, true
+	}
+
+	var values []jsonValue
+	var stack []*jsonFrame
+	topLevelValues := 0
+	decoder := json.NewDecoder(bytes.NewReader(textBytes))
+	for {
+		start := skipJSONSeparators(textBytes, int(decoder.InputOffset()))
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false
+		}
+		end := int(decoder.InputOffset())
+
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && top.expectKey {
+			if delim, ok := token.(json.Delim); !ok || delim != '}' {
+				top.key, _ = token.(string)
+				top.expectKey = false
+				continue
+			}
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			frame := &jsonFrame{array: token == json.Delim('['), start: start}
+			frame.expectKey = !frame.array
+			frame.pathIndexes = m.matchedPaths(stack)
+			stack = append(stack, frame)
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			for _, pathIndex := range top.pathIndexes {
+				values = append(values, jsonValue{pathIndex: pathIndex, start: top.start, end: end, value: textBytes[top.start:end]})
+			}
+		default:
+			for _, pathIndex := range m.matchedPaths(stack) {
+				values = append(values, newJSONValue(pathIndex, textBytes, start, end))
+			}
+		}
+
+		// A value is done.
+		if len(stack) == 0 {
+			topLevelValues++
+		} else if parent := stack[len(stack)-1]; parent.array {
+			parent.index++
+		} else {
+			parent.expectKey = true
+		}
+	}
+	if topLevelValues != 1 {
+		return nil, false
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].start < values[j].start
+	})
+	return values, true
+}
+
+// matchedPaths returns indexes of paths which select the value in the last
+// frame of stack.
+func (m *jsonMatcher) matchedPaths(stack []*jsonFrame) []int {
+	var indexes []int
+	for i, path := range m.paths {
+		if len(path.elements) != len(stack) {
+			continue
+		}
+		matched := true
+		for j, element := range path.elements {
+			if !element.matches(stack[j]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// matches checks element selects the current value in frame.
+func (element jsonPathElement) matches(frame *jsonFrame) bool {
+	switch element.index {
+	case -1:
+		return !frame.array && frame.key == element.key
+	case -2:
+		return frame.array
+	}
+	return frame.array && frame.index == element.index
+}
+
+func newJSONValue(pathIndex int, textBytes []byte, start, end int) jsonValue {
+	v := jsonValue{pathIndex: pathIndex, start: start, end: end, value: textBytes[start:end]}
+	if end-start < 2 || textBytes[start] != '"' {
+		return v
+	}
+
+	v.start, v.end = start+1, end-1
+	v.value = textBytes[v.start:v.end]
+	if bytes.IndexByte(v.value, '\\') < 0 {
+		return v
+	}
+
+	v.value = make([]byte, 0, v.end-v.start)
+	v.offsets = make([]int, 0, v.end-v.start)
+	for i := v.start; i < v.end; {
+		if textBytes[i] != '\\' {
+			v.value = append(v.value, textBytes[i])
+			v.offsets = append(v.offsets, i)
+			i++
+			continue
+		}
+		escapeStart := i
+		r, n := unescapeJSON(textBytes[i:v.end])
+		i += n
+		var buf [utf8.UTFMax]byte
+		for _, b := range buf[:utf8.EncodeRune(buf[:], r)] {
+			v.value = append(v.value, b)
+			v.offsets = append(v.offsets, escapeStart)
+		}
+	}
+	return v
+}
+
+// unescapeJSON decodes an escape sequence at the start of text. It returns
+// the rune and the length of the sequence.
+func unescapeJSON(text []byte) (rune, int) {
+	if len(text) < 2 {
+		return utf8.RuneError, len(text)
+	}
+	switch text[1] {
+	case 'b':
+		return '\b', 2
+	case 'f':
+		return '\f', 2
+	case 'n':
+		return '\n', 2
+	case 'r':
+		return '\r', 2
+	case 't':
+		return '\t', 2
+	case 'u':
+		if len(text) < 6 {
+			return utf8.RuneError, len(text)
+		}
+		r1, err := strconv.ParseUint(string(text[2:6]), 16, 16)
+		if err != nil {
+			return utf8.RuneError, 6
+		}
+		if utf16.IsSurrogate(rune(r1)) && len(text) >= 12 && text[6] == '\\' && text[7] == 'u' {
+			if r2, err := strconv.ParseUint(string(text[8:12]), 16, 16); err == nil {
+				if r := utf16.DecodeRune(rune(r1), rune(r2)); r != utf8.RuneError {
+					return r, 12
+				}
+			}
+		}
+		return rune(r1), 6
+	}
+	return rune(text[1]), 2
+}
+
+// lineOffset converts an offset of value to an offset of the line.
+func (v *jsonValue) lineOffset(pos int) int {
+	if v.offsets == nil {
+		return v.start + pos
+	}
+	if pos < len(v.offsets) {
+		return v.offsets[pos]
+	}
+	return v.end
+}
+
+// skipJSONSeparators skips white spaces, ',' and ':' before a token.
+func skipJSONSeparators(textBytes []byte, pos int) int {
+	for pos < len(textBytes) {
+		switch textBytes[pos] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			pos++
+		default:
+			return pos
+		}
+	}
+	return pos
+}
@@ -0,0 +1,95 @@
+package book
+
+import (
+	"testing"
+)
+
+func TestParseJSONPaths(t *testing.T) {
+	paths, err := ParseJSONPaths(".level, .req.path,.tags[0],.tags[],[]")
+	if err != nil || len(paths) != 5 || paths[1] != ".req.path" {
+		t.Error("ParseJSONPaths returned unexpected paths.", paths, err)
+	}
+	for _, spec := range []string{"", "level", ".", ".a..b", ".a[", ".a[-1]", ".a[x]"} {
+		if _, err := ParseJSONPaths(spec); err == nil {
+			t.Error("Invalid path should be an error.", spec)
+		}
+	}
+}
+
+func TestJSONMatcher(t *testing.T) {
+	m := newJSONMatcher(NewMatcher(&MatcherParams{Patterns: []string{"error"}}), &JSONParams{Paths: []string{".level", ".req.path"}})
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		{`{"level":"error","msg":"x"}`, true},
+		{`{"msg":"error","level":"info"}`, false},
+		{`{"level":"info","req":{"path":"/error"}}`, true},
+		{`{"error":1,"req":{"path":"/","level":"error"}}`, false},
+		{`{"level":"error"}`, true},
+		{`level=error`, false},
+		{`{"level":"error"} {}`, false},
+		{``, false},
+	}
+	for _, test := range tests {
+		if m.Match([]byte(test.text)) != test.expected {
+			t.Error("jsonMatcher returned an unexpected result.", test.text)
+		}
+	}
+
+	line := []byte(`{"level": "e\"rror", "req": {"path": "/error"}}`)
+	spans := m.MatchSpans(line)
+	if len(spans) != 1 || string(line[spans[0].Start:spans[0].End]) != "error" {
+		t.Error("MatchSpans should return offsets in the line.", spans)
+	}
+	if names := m.matchedFields(line); len(names) != 1 || names[0] != ".req.path" {
+		t.Error("matchedFields should return matched paths.", names)
+	}
+
+	m = newJSONMatcher(NewMatcher(&MatcherParams{Patterns: []string{`"b"`, "2"}}), &JSONParams{Paths: []string{".tags[1]", ".obj"}})
+	if !m.Match([]byte(`{"tags":["a",2]}`)) || m.Match([]byte(`{"tags":[2,"a"]}`)) {
+		t.Error("jsonMatcher should select array elements by indexes.")
+	}
+	if !m.Match([]byte(`{"obj":{"a":"b"}}`)) {
+		t.Error("jsonMatcher should match an object as JSON text.")
+	}
+
+	m = newJSONMatcher(NewMatcher(&MatcherParams{Patterns: []string{"x"}}), &JSONParams{Paths: []string{".a[]"}, ReportInvalid: true})
+	if !m.Match([]byte(`{"a":["y","x"]}`)) || m.Match([]byte(`{"a":"x"}`)) {
+		t.Error("jsonMatcher should select every element of an array.")
+	}
+	if !m.Match([]byte(`not json`)) {
+		t.Error("Invalid line should be selected with ReportInvalid.")
+	}
+	if names := m.matchedFields([]byte(`{"a":`)); len(names) != 1 || names[0] != InvalidJSONName {
+		t.Error("Invalid line should be reported.", names)
+	}
+}
+
+func TestFindJSON(t *testing.T) {
+	c := newChapterBytes([]byte("{\"level\":\"info\",\"msg\":\"error\"}\nbroken error\n{\"level\":\"error\"}\n"))
+	defer c.Close()
+	var lineNumbers []LineNumber
+	var fields []string
+	count := c.Find(&FindParams{
+		Patterns: []string{"error"},
+		JSON:     &JSONParams{Paths: []string{".level"}},
+		Handler: func(params *FoundParams) {
+			lineNumbers = append(lineNumbers, params.lineNumber)
+			fields = append(fields, params.MatchedFields()...)
+		},
+	})
+	if count != 1 || len(lineNumbers) != 1 || lineNumbers[0] != 3 {
+		t.Error("Find should select lines by JSON values.", count, lineNumbers)
+	}
+	if len(fields) != 1 || fields[0] != ".level" {
+		t.Error("MatchedFields should return the matched path.", fields)
+	}
+
+	c = newChapterBytes([]byte("{\"level\":\"info\"}\nbroken error\n\n{\"level\":\"error\"}\n"))
+	defer c.Close()
+	count = c.Find(&FindParams{Patterns: []string{"error"}, JSON: &JSONParams{Paths: []string{".level"}}, InvertMatch: true})
+	if count != 1 {
+		t.Error("Find shouldn't select lines which aren't JSON when inverted.", count)
+	}
+}
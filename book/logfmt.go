@@ -0,0 +1,206 @@
+package book
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LogfmtCondition is a condition on values of Key in a logfmt line like
+// `level=error msg="read timeout"`. Value is compared with the whole value,
+// or it is a regexp which matches a part of the value if Regexp is set.
+type LogfmtCondition struct {
+	Key    string
+	Value  string
+	Regexp bool
+}
+
+// LogfmtParams selects logfmt lines which satisfy all Conditions. Patterns
+// are matched with the whole line if they are given. Values of conditions
+// are matched with the options of patterns. e.g. -i, -F, -w
+type LogfmtParams struct {
+	Conditions []*LogfmtCondition
+	// IgnoreCases overrides IgnoreCase of patterns for each condition when
+	// it is set. e.g. smart case
+	IgnoreCases []bool
+}
+
+// logfmtPair is a key and its value in a line. A quoted value is unquoted
+// like a JSON string.
+type logfmtPair struct {
+	key   []byte
+	value jsonValue
+}
+
+// logfmtMatcher selects lines which satisfy all conditions and match
+// matcher if patterns are given. Spans of conditions have pattern indexes
+// from patternCount.
+type logfmtMatcher struct {
+	matcher      Matcher
+	hasPatterns  bool
+	patternCount int
+	conditions   []*LogfmtCondition
+	// valueMatchers match values of conditions.
+	valueMatchers []Matcher
+}
+
+// ParseLogfmtCondition parses "key=value" or "key~regexp".
+func ParseLogfmtCondition(text string) (*LogfmtCondition, error) {
+	n := strings.IndexAny(text, "=~")
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid logfmt field %q", text)
+	}
+	c := &LogfmtCondition{Key: text[:n], Value: text[n+1:], Regexp: text[n] == '~'}
+	if c.Regexp {
+		if _, err := regexp.Compile(c.Value); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *LogfmtCondition) String() string {
+	if c.Regexp {
+		return c.Key + "~" + c.Value
+	}
+	return c.Key + "=" + c.Value
+}
+
+// newLogfmtMatcher creates a logfmtMatcher. patternParams are options of
+// patterns, and values of conditions are matched with them. A value of
+// key=value is compared with the whole value as a fixed string.
+func newLogfmtMatcher(m Matcher, params *LogfmtParams, patternParams *MatcherParams, hasPatterns bool,
+	patternCount int) (lm *logfmtMatcher) {
+	lm = new(logfmtMatcher)
+	lm.matcher = m
+	lm.hasPatterns = hasPatterns
+	lm.patternCount = patternCount
+	lm.conditions = params.Conditions
+	lm.valueMatchers = make([]Matcher, len(params.Conditions))
+	for i, c := range params.Conditions {
+		ignoreCase := patternParams.IgnoreCase
+		if params.IgnoreCases != nil {
+			ignoreCase = params.IgnoreCases[i]
+		}
+		lm.valueMatchers[i] = NewMatcher(&MatcherParams{
+			Patterns:     []string{c.Value},
+			IgnoreCase:   ignoreCase,
+			FixedStrings: !c.Regexp || patternParams.FixedStrings,
+			WordRegexp:   c.Regexp && patternParams.WordRegexp,
+			LineRegexp:   !c.Regexp || patternParams.LineRegexp,
+		})
+	}
+	return
+}
+
+// conditionSpans returns spans of value which satisfy the condition at
+// index.
+func (m *logfmtMatcher) conditionSpans(index int, value []byte) []MatchSpan {
+	return m.valueMatchers[index].MatchSpans(value)
+}
+
+func (m *logfmtMatcher) Match(textBytes []byte) bool {
+	pairs := parseLogfmt(textBytes)
+	for i := range m.conditions {
+		if !m.satisfies(i, pairs) {
+			return false
+		}
+	}
+	return !m.hasPatterns || m.matcher.Match(textBytes)
+}
+
+// satisfies checks a pair satisfies the condition at index.
+func (m *logfmtMatcher) satisfies(index int, pairs []logfmtPair) bool {
+	for _, pair := range pairs {
+		if string(pair.key) == m.conditions[index].Key && len(m.conditionSpans(index, pair.value.value)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *logfmtMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	var spans []MatchSpan
+	if m.hasPatterns {
+		spans = m.matcher.MatchSpans(textBytes)
+	}
+	for _, pair := range parseLogfmt(textBytes) {
+		for i, c := range m.conditions {
+			if string(pair.key) != c.Key {
+				continue
+			}
+			for _, span := range m.conditionSpans(i, pair.value.value) {
+				spans = append(spans, MatchSpan{
+					Start:        pair.value.lineOffset(span.Start),
+					End:          pair.value.lineOffset(span.End),
+					PatternIndex: m.patternCount + i,
+				})
+			}
+		}
+	}
+	sort.Stable(matchSpans(spans))
+	return spans
+}
+
+// parseLogfmt splits textBytes into key and value pairs. A key without '='
+// has an empty value. Values can be quoted with '"' and have escapes.
+func parseLogfmt(textBytes []byte) []logfmtPair {
+	var pairs []logfmtPair
+	i := 0
+	for i < len(textBytes) {
+		for i < len(textBytes) && isLogfmtSpace(textBytes[i]) {
+			i++
+		}
+		keyStart := i
+		for i < len(textBytes) && textBytes[i] != '=' && !isLogfmtSpace(textBytes[i]) {
+			i++
+		}
+		if i == keyStart {
+			if i < len(textBytes) {
+				// '=' without a key.
+				i = skipLogfmtValue(textBytes, i+1)
+			}
+			continue
+		}
+		pair := logfmtPair{key: textBytes[keyStart:i]}
+		if i >= len(textBytes) || textBytes[i] != '=' {
+			pair.value = jsonValue{start: i, end: i, value: textBytes[i:i]}
+			pairs = append(pairs, pair)
+			continue
+		}
+		valueStart := i + 1
+		i = skipLogfmtValue(textBytes, valueStart)
+		// A quoted value is unquoted like a JSON string.
+		pair.value = newJSONValue(0, textBytes, valueStart, i)
+		if i-valueStart >= 2 && textBytes[valueStart] == '"' && textBytes[i-1] != '"' {
+			pair.value = jsonValue{start: valueStart, end: i, value: textBytes[valueStart:i]}
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+// skipLogfmtValue returns the end of the value at start.
+func skipLogfmtValue(textBytes []byte, start int) int {
+	i := start
+	if i < len(textBytes) && textBytes[i] == '"' {
+		for i++; i < len(textBytes); i++ {
+			if textBytes[i] == '\\' {
+				i++
+			} else if textBytes[i] == '"' {
+				return i + 1
+			}
+		}
+		return len(textBytes)
+	}
+	if n := bytes.IndexAny(textBytes[i:], " \t"); n >= 0 {
+		return i + n
+	}
+	return len(textBytes)
+}
+
+func isLogfmtSpace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
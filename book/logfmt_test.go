@@ -0,0 +1,123 @@
+package book
+
+import (
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	line := []byte(`level=error msg="read \"x\" timeout" dur=3s debug =x empty= q="open`)
+	expected := []struct {
+		key   string
+		value string
+	}{
+		{"level", "error"},
+		{"msg", `read "x" timeout`},
+		{"dur", "3s"},
+		{"debug", ""},
+		{"empty", ""},
+		{"q", `"open`},
+	}
+	pairs := parseLogfmt(line)
+	if len(pairs) != len(expected) {
+		t.Fatal("parseLogfmt returned unexpected pairs.", len(pairs))
+	}
+	for i, e := range expected {
+		if string(pairs[i].key) != e.key || string(pairs[i].value.value) != e.value {
+			t.Error("parseLogfmt returned an unexpected pair.", string(pairs[i].key), string(pairs[i].value.value))
+		}
+	}
+}
+
+func TestParseLogfmtCondition(t *testing.T) {
+	c, err := ParseLogfmtCondition("msg~time=out")
+	if err != nil || c.Key != "msg" || c.Value != "time=out" || !c.Regexp || c.String() != "msg~time=out" {
+		t.Error("ParseLogfmtCondition returned an unexpected condition.", c, err)
+	}
+	for _, text := range []string{"level", "=error", "msg~("} {
+		if _, err := ParseLogfmtCondition(text); err == nil {
+			t.Error("Invalid condition should be an error.", text)
+		}
+	}
+}
+
+func TestLogfmtMatcher(t *testing.T) {
+	level, _ := ParseLogfmtCondition("level=error")
+	msg, _ := ParseLogfmtCondition("msg~timeout")
+	params := &LogfmtParams{Conditions: []*LogfmtCondition{level, msg}}
+	m := newLogfmtMatcher(NewMatcher(&MatcherParams{}), params, &MatcherParams{}, false, 0)
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		{`level=error msg="read timeout"`, true},
+		{`level=errors msg="read timeout"`, false},
+		{`level=error msg=ok note=timeout`, false},
+		{`msg=timeout level=error`, true},
+	}
+	for _, test := range tests {
+		if m.Match([]byte(test.text)) != test.expected {
+			t.Error("logfmtMatcher returned an unexpected result.", test.text)
+		}
+	}
+
+	line := []byte(`level=error msg="a \"b\" timeout"`)
+	spans := m.MatchSpans(line)
+	if len(spans) != 2 || string(line[spans[0].Start:spans[0].End]) != "error" ||
+		string(line[spans[1].Start:spans[1].End]) != "timeout" || spans[1].PatternIndex != 1 {
+		t.Error("MatchSpans should return values of conditions.", spans)
+	}
+
+	m = newLogfmtMatcher(NewMatcher(&MatcherParams{Patterns: []string{"db"}}), params, &MatcherParams{}, true, 1)
+	if !m.Match([]byte(`level=error msg=timeout svc=db`)) || m.Match([]byte(`level=error msg=timeout svc=web`)) {
+		t.Error("logfmtMatcher should match patterns with the line.")
+	}
+	spans = m.MatchSpans([]byte(`level=error msg=timeout svc=db`))
+	if len(spans) != 3 || spans[2].PatternIndex != 0 || spans[0].PatternIndex != 1 {
+		t.Error("Conditions should have pattern indexes after patterns.", spans)
+	}
+}
+
+func TestLogfmtMatcherPatternOptions(t *testing.T) {
+	level, _ := ParseLogfmtCondition("level=ERROR")
+	msg, _ := ParseLogfmtCondition("msg~a.b")
+	empty, _ := ParseLogfmtCondition("svc=")
+	params := &LogfmtParams{Conditions: []*LogfmtCondition{level, msg, empty}}
+	line := []byte(`level=error msg="x a.b" svc=`)
+
+	m := newLogfmtMatcher(NewMatcher(&MatcherParams{}), params, &MatcherParams{}, false, 0)
+	if m.Match(line) {
+		t.Error("Conditions should be case sensitive by default.")
+	}
+	m = newLogfmtMatcher(NewMatcher(&MatcherParams{}), params, &MatcherParams{IgnoreCase: true}, false, 0)
+	if !m.Match(line) || m.Match([]byte(`level=errors msg="x a.b" svc=`)) {
+		t.Error("key=value should match the whole value ignoring case.")
+	}
+	m = newLogfmtMatcher(NewMatcher(&MatcherParams{}), params,
+		&MatcherParams{IgnoreCase: true, FixedStrings: true, WordRegexp: true}, false, 0)
+	if !m.Match(line) || m.Match([]byte(`level=error msg="x axb" svc=`)) ||
+		m.Match([]byte(`level=error msg="xa.b" svc=`)) {
+		t.Error("key~value should be a fixed word with -F and -w.")
+	}
+
+	params.IgnoreCases = []bool{true, false, false}
+	m = newLogfmtMatcher(NewMatcher(&MatcherParams{}), params, &MatcherParams{}, false, 0)
+	if !m.Match(line) {
+		t.Error("IgnoreCases should override IgnoreCase of patterns.")
+	}
+}
+
+func TestFindLogfmt(t *testing.T) {
+	c := newChapterBytes([]byte("level=info msg=timeout\nlevel=error msg=timeout\n"))
+	defer c.Close()
+	level, _ := ParseLogfmtCondition("level=error")
+	var labels []string
+	count := c.Find(&FindParams{
+		Logfmt: &LogfmtParams{Conditions: []*LogfmtCondition{level}},
+		Handler: func(params *FoundParams) {
+			labels = append(labels, params.MatchedPatternLabels()...)
+		},
+	})
+	if count != 1 || len(labels) != 1 || labels[0] != "level=error" {
+		t.Error("Find should select lines by logfmt conditions.", count, labels)
+	}
+}
@@ -2,29 +2,108 @@ package book
 
 import (
 	"bytes"
-	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+type MatcherParams struct {
+	Patterns   []string
+	IgnoreCase bool
+	// IgnoreCases overrides IgnoreCase for each pattern when it is set.
+	IgnoreCases  []bool
+	FixedStrings bool
+	// WordRegexp selects matches which form whole words.
+	WordRegexp bool
+	// LineRegexp selects matches which form the whole line.
+	LineRegexp bool
+	// MaxErrors enables approximate matching of fixed strings. It is the
+	// number of insertions, deletions and substitutions allowed in a match.
+	// WordRegexp and LineRegexp are not supported in this mode.
+	MaxErrors int
+	// PerlRegexp selects the backtracking engine for Perl compatible
+	// patterns with lookaround and backreferences.
+	PerlRegexp bool
+	// LongestMatch selects the leftmost longest match of POSIX regexps
+	// instead of the leftmost first one. e.g. -E 'a|ab' finds "ab"
+	LongestMatch bool
+	// lineError receives an error of matching a line. e.g. ErrBacktrackLimit
+	lineError *lineError
+}
+
+// MatchSpan is a matched range in a line. Start and End are byte offsets
+// of the line and PatternIndex is the index of the pattern which matched.
+type MatchSpan struct {
+	Start        int
+	End          int
+	PatternIndex int
+}
+
 type Matcher interface {
 	Match(bytes []byte) bool
+	// MatchSpans returns all matched ranges ordered by Start position.
+	MatchSpans(bytes []byte) []MatchSpan
 }
 
+type matchSpans []MatchSpan
+
 type matcher struct {
-	patterns   []string
-	ignoreCase bool
+	patterns    []string
+	ignoreCase  bool
+	ignoreCases []bool
+	wordRegexp  bool
+	lineRegexp  bool
 }
 
 type regexpMatcher struct {
 	matcher
 	regexpPatterns []*regexp.Regexp
+	prefilters     []*literalPrefilter
+	// presetChecks confirm matches of built-in presets.
+	presetChecks []presetCheck
 }
 
 type bytesMatcher struct {
 	matcher
-	bytesPatterns      [][]byte
-	upperBytesPatterns [][]byte
+	bytesPatterns [][]byte
+	foldPatterns  []*foldPattern
+	// automaton finds case sensitive patterns. Their indexes are
+	// automatonIndexes.
+	automaton        *ahoCorasick
+	automatonIndexes []int
+	// foldAutomaton finds folded patterns which ignore case in folded text.
+	// Their indexes are foldAutomatonIndexes. It only finds candidates, and
+	// they are confirmed by foldPatterns.
+	foldAutomaton        *ahoCorasick
+	foldAutomatonIndexes []int
+}
+
+// isIgnoreCase returns whether the pattern at patternIndex ignores case.
+func (m *matcher) isIgnoreCase(patternIndex int) bool {
+	if m.ignoreCases != nil {
+		return m.ignoreCases[patternIndex]
+	}
+	return m.ignoreCase
+}
+
+// e.g.
+// m := NewMatcher(&MatcherParams{Patterns: []string{"foo"}, WordRegexp: true})
+func NewMatcher(params *MatcherParams) Matcher {
+	if params.PerlRegexp {
+		return newBacktrackMatcher(params)
+	}
+	if params.MaxErrors > 0 {
+		return newFuzzyMatcher(params)
+	}
+	if params.FixedStrings {
+		return newBytesMatcherWithParams(params)
+	}
+	if literalParams := literalMatcherParams(params); literalParams != nil {
+		return newBytesMatcherWithParams(literalParams)
+	}
+	return newRegexpMatcherWithParams(params)
 }
 
 func NewRegexpMatcher(patterns []string, ignoreCase bool) Matcher {
@@ -35,26 +114,52 @@ func NewBytesMatcher(patterns []string, ignoreCase bool) Matcher {
 	return newBytesMatcher(patterns, ignoreCase)
 }
 
-// TODO: ignoreCase should be supported.
-func newRegexpMatcher(patterns []string, ignoreCase bool) (m *regexpMatcher) {
+func newRegexpMatcher(patterns []string, ignoreCase bool) *regexpMatcher {
+	return newRegexpMatcherWithParams(&MatcherParams{Patterns: patterns, IgnoreCase: ignoreCase})
+}
+
+func newRegexpMatcherWithParams(params *MatcherParams) (m *regexpMatcher) {
 	m = new(regexpMatcher)
-	m.patterns = patterns
-	m.ignoreCase = ignoreCase
+	m.patterns = params.Patterns
+	m.ignoreCase = params.IgnoreCase
+	m.ignoreCases = params.IgnoreCases
+	m.wordRegexp = params.WordRegexp
+	m.lineRegexp = params.LineRegexp
+
+	m.presetChecks = presetChecksOf(m.patterns)
 
 	m.regexpPatterns = make([]*regexp.Regexp, len(m.patterns))
+	m.prefilters = make([]*literalPrefilter, len(m.patterns))
 	for i, p := range m.patterns {
-		if ignoreCase {
+		if m.isIgnoreCase(i) {
 			p = m.toIgnoreRegexpPattern(p)
 		}
+		if m.lineRegexp {
+			p = "^(?:" + p + ")$"
+		}
+		m.prefilters[i] = newLiteralPrefilter(p)
+		if m.wordRegexp && !m.lineRegexp {
+			p = toWordRegexpPattern(p)
+		}
 		m.regexpPatterns[i], _ = regexp.Compile(p)
+		if params.LongestMatch && m.regexpPatterns[i] != nil {
+			m.regexpPatterns[i].Longest()
+		}
 	}
 
 	return
 }
 
 func (m *regexpMatcher) Match(textBytes []byte) bool {
-	for _, p := range m.regexpPatterns {
-		if p.Match(textBytes) {
+	for i, p := range m.regexpPatterns {
+		if m.prefilters[i] != nil && !m.prefilters[i].contains(textBytes) {
+			continue
+		}
+		if (m.wordRegexp && !m.lineRegexp) || m.presetChecks != nil {
+			if len(m.findIndex(i, textBytes, 1)) > 0 {
+				return true
+			}
+		} else if p.Match(textBytes) {
 			return true
 		}
 	}
@@ -62,44 +167,157 @@ func (m *regexpMatcher) Match(textBytes []byte) bool {
 	return false
 }
 
-// TODO: This doesn't handle Unicode. So, if double byte characters are used, then it may create
-// wrong bytes.
-func (m *regexpMatcher) toIgnoreRegexpPattern(pattern string) string {
-	var buffer bytes.Buffer
-	patternBytes := []byte(pattern)
-	lowerBytes := []byte(strings.ToLower(pattern))
-	upperBytes := []byte(strings.ToUpper(pattern))
-	patternLen := len(patternBytes)
-
-	for i := 0; i < patternLen; i++ {
-		if lowerBytes[i] != upperBytes[i] {
-			buffer.WriteString(fmt.Sprintf("(%s|%s)", string(lowerBytes[i]), string(upperBytes[i])))
+func (m *regexpMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	var spans []MatchSpan
+	for i := range m.regexpPatterns {
+		if m.prefilters[i] != nil && !m.prefilters[i].contains(textBytes) {
+			continue
+		}
+		for _, loc := range m.findIndex(i, textBytes, -1) {
+			spans = append(spans, MatchSpan{Start: loc[0], End: loc[1], PatternIndex: i})
+		}
+	}
+	sort.Stable(matchSpans(spans))
+	return spans
+}
+
+// findIndex returns up to n matches of the pattern at patternIndex. They
+// are whole words with WordRegexp, and matches of built-in presets are
+// confirmed by their checks.
+func (m *regexpMatcher) findIndex(patternIndex int, textBytes []byte, n int) [][]int {
+	p := m.regexpPatterns[patternIndex]
+	if m.wordRegexp && !m.lineRegexp {
+		locs := m.findWordIndex(p, textBytes, -1)
+		checked := locs[:0]
+		for _, loc := range locs {
+			if acceptsPresetMatch(m.presetChecks, patternIndex, textBytes, loc[0], loc[1]) {
+				checked = append(checked, loc)
+			}
+		}
+		if n >= 0 && len(checked) > n {
+			checked = checked[:n]
+		}
+		return checked
+	}
+	if m.presetChecks == nil || m.presetChecks[patternIndex] == nil {
+		return p.FindAllIndex(textBytes, n)
+	}
+	return findCheckedIndex(p, m.presetChecks[patternIndex], textBytes, n)
+}
+
+// findCheckedIndex is like FindAllIndex of regexp but check confirms each
+// match. A rejected match is searched again from its next character, so a
+// shorter match in it can be found.
+func findCheckedIndex(p *regexp.Regexp, check presetCheck, textBytes []byte, n int) [][]int {
+	var locs [][]int
+	for start := 0; start <= len(textBytes) && (n < 0 || len(locs) < n); {
+		loc := p.FindIndex(textBytes[start:])
+		if loc == nil {
+			break
+		}
+		matchStart, matchEnd := start+loc[0], start+loc[1]
+		if check(textBytes, matchStart, matchEnd) {
+			locs = append(locs, []int{matchStart, matchEnd})
+			if matchStart < matchEnd {
+				start = matchEnd
+				continue
+			}
+		}
+		_, size := utf8.DecodeRune(textBytes[matchStart:])
+		if size == 0 {
+			break
+		}
+		start = matchStart + size
+	}
+	return locs
+}
+
+// toWordRegexpPattern surrounds pattern by non-word characters. The
+// pattern is the first group. Other alternatives of the pattern are tried
+// when a match is not a whole word. e.g. "foo|foobar" for "foobar"
+func toWordRegexpPattern(pattern string) string {
+	return `(?:^|[^\pL\p{Nd}\pM_])(` + pattern + `)(?:[^\pL\p{Nd}\pM_]|$)`
+}
+
+// findWordIndex is like FindAllIndex of regexp but it returns matches of
+// the first group of a pattern by toWordRegexpPattern. A match ends before
+// the following non-word character, so searching is continued from there.
+// ^ of the pattern matches where searching is continued, so a match there
+// is checked against the whole text and skipped unless it is a word.
+func (m *regexpMatcher) findWordIndex(p *regexp.Regexp, textBytes []byte, n int) [][]int {
+	var locs [][]int
+	for start := 0; start <= len(textBytes) && (n < 0 || len(locs) < n); {
+		loc := p.FindSubmatchIndex(textBytes[start:])
+		if loc == nil {
+			break
+		}
+		matchStart, matchEnd := start+loc[2], start+loc[3]
+		if !isWordBoundary(textBytes, matchStart, matchEnd) {
+			_, size := utf8.DecodeRune(textBytes[matchStart:])
+			if size == 0 {
+				break
+			}
+			start = matchStart + size
+		} else if matchStart < matchEnd {
+			locs = append(locs, []int{matchStart, matchEnd})
+			start = matchEnd
 		} else {
-			buffer.WriteString(string(patternBytes[i]))
+			_, size := utf8.DecodeRune(textBytes[matchEnd:])
+			if size == 0 {
+				break
+			}
+			start = matchEnd + size
 		}
 	}
+	return locs
+}
 
-	return buffer.String()
+// toIgnoreRegexpPattern wraps pattern by a group with the case insensitive
+// flag. regexp folds Unicode case of literals and character classes, so
+// escapes and classes keep their meaning.
+func (m *regexpMatcher) toIgnoreRegexpPattern(pattern string) string {
+	return "(?i:" + pattern + ")"
 }
 
-func newBytesMatcher(patterns []string, ignoreCase bool) (m *bytesMatcher) {
+func newBytesMatcher(patterns []string, ignoreCase bool) *bytesMatcher {
+	return newBytesMatcherWithParams(&MatcherParams{Patterns: patterns, IgnoreCase: ignoreCase})
+}
+
+func newBytesMatcherWithParams(params *MatcherParams) (m *bytesMatcher) {
 	m = new(bytesMatcher)
 
+	patterns := params.Patterns
 	m.patterns = patterns
-	m.ignoreCase = ignoreCase
+	m.ignoreCase = params.IgnoreCase
+	m.ignoreCases = params.IgnoreCases
+	m.wordRegexp = params.WordRegexp
+	m.lineRegexp = params.LineRegexp
 
 	m.bytesPatterns = make([][]byte, len(patterns))
+	m.foldPatterns = make([]*foldPattern, len(patterns))
 
-	if ignoreCase {
-		m.upperBytesPatterns = make([][]byte, len(patterns))
-	}
-
+	var exactPatterns, foldedPatterns [][]byte
 	for i, p := range patterns {
-		if ignoreCase {
+		if m.isIgnoreCase(i) {
 			m.bytesPatterns[i] = []byte(strings.ToLower(p))
-			m.upperBytesPatterns[i] = []byte(strings.ToUpper(p))
+			m.foldPatterns[i] = newFoldPattern([]byte(p))
+			foldedPatterns = append(foldedPatterns, foldBytes([]byte(p)))
+			m.foldAutomatonIndexes = append(m.foldAutomatonIndexes, i)
 		} else {
 			m.bytesPatterns[i] = []byte(p)
+			exactPatterns = append(exactPatterns, m.bytesPatterns[i])
+			m.automatonIndexes = append(m.automatonIndexes, i)
+		}
+	}
+
+	if len(patterns) >= ahoCorasickPatternThreshold && !m.wordRegexp && !m.lineRegexp {
+		if len(exactPatterns) > 0 {
+			m.automaton = newAhoCorasick(exactPatterns)
+		}
+		// Folded text can have a different length from the text. e.g.
+		// "\u212A" for "k". So it is only used to find candidates.
+		if len(foldedPatterns) > 0 {
+			m.foldAutomaton = newAhoCorasick(foldedPatterns)
 		}
 	}
 
@@ -107,17 +325,35 @@ func newBytesMatcher(patterns []string, ignoreCase bool) (m *bytesMatcher) {
 }
 
 func (m *bytesMatcher) Match(textBytes []byte) bool {
+	if m.automaton != nil || m.foldAutomaton != nil {
+		if m.automaton != nil && m.automaton.Match(textBytes) {
+			return true
+		}
+		for _, i := range m.foldCandidates(textBytes) {
+			if start, _ := m.foldPatterns[i].index(textBytes); start >= 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	if m.wordRegexp || m.lineRegexp {
+		for i := range m.bytesPatterns {
+			if len(m.findAllIndex(i, textBytes, 1)) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+
 	for i, p := range m.bytesPatterns {
-		if len(textBytes) >= len(p) {
-			if m.ignoreCase {
-				up := m.upperBytesPatterns[i]
-				if m.containsIgnoreCase(p, up, textBytes) {
-					return true
-				}
-			} else {
-				if bytes.Contains(textBytes, p) {
-					return true
-				}
+		if m.foldPatterns[i] != nil {
+			if start, _ := m.foldPatterns[i].index(textBytes); start >= 0 {
+				return true
+			}
+		} else if len(textBytes) >= len(p) {
+			if bytes.Contains(textBytes, p) {
+				return true
 			}
 		}
 	}
@@ -125,30 +361,125 @@ func (m *bytesMatcher) Match(textBytes []byte) bool {
 	return false
 }
 
-func (m *bytesMatcher) containsIgnoreCase(lowerPattern, upperPattern, textBytes []byte) bool {
-	matchPos := 0
-	textLen := len(textBytes)
-	patternLen := len(lowerPattern)
+func (m *bytesMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	var spans []MatchSpan
+	if m.automaton != nil || m.foldAutomaton != nil {
+		if m.automaton != nil {
+			for _, span := range m.automaton.MatchSpans(textBytes) {
+				span.PatternIndex = m.automatonIndexes[span.PatternIndex]
+				spans = append(spans, span)
+			}
+		}
+		for _, i := range m.foldCandidates(textBytes) {
+			for _, loc := range m.findAllIndex(i, textBytes, -1) {
+				spans = append(spans, MatchSpan{Start: loc[0], End: loc[1], PatternIndex: i})
+			}
+		}
+	} else {
+		for i := range m.bytesPatterns {
+			for _, loc := range m.findAllIndex(i, textBytes, -1) {
+				spans = append(spans, MatchSpan{Start: loc[0], End: loc[1], PatternIndex: i})
+			}
+		}
+	}
+	sort.Stable(matchSpans(spans))
+	return spans
+}
+
+// foldCandidates returns indexes of patterns ignoring case which can be in
+// textBytes.
+func (m *bytesMatcher) foldCandidates(textBytes []byte) []int {
+	if m.foldAutomaton == nil {
+		return nil
+	}
+	indexes := m.foldAutomaton.patternIndexes(foldBytes(textBytes))
+	for i, index := range indexes {
+		indexes[i] = m.foldAutomatonIndexes[index]
+	}
+	return indexes
+}
 
-	// TODO: There is a better matcching way.
-	for startPos := 0; startPos < textLen; startPos++ {
-		if textLen-startPos < patternLen {
-			return false
+// findAllIndex returns at most n non-overlapping ranges of the pattern at
+// patternIndex in textBytes. If n < 0, it returns all ranges.
+func (m *bytesMatcher) findAllIndex(patternIndex int, textBytes []byte, n int) [][]int {
+	if m.lineRegexp {
+		if start, end := m.index(patternIndex, textBytes); start == 0 && end == len(textBytes) {
+			return [][]int{{0, end}}
 		}
-		matchPos = 0
+		return nil
+	}
 
-		for i := startPos; i < textLen; i++ {
-			b := textBytes[i]
-			if b == lowerPattern[matchPos] || b == upperPattern[matchPos] {
-				matchPos++
-				if matchPos == patternLen {
-					return true
-				}
-			} else {
-				break
-			}
+	if len(m.bytesPatterns[patternIndex]) == 0 {
+		if m.wordRegexp {
+			return nil
 		}
+		return [][]int{{0, 0}}
 	}
 
-	return false
+	var locs [][]int
+	for start := 0; start < len(textBytes) && (n < 0 || len(locs) < n); {
+		matchStart, matchEnd := m.index(patternIndex, textBytes[start:])
+		if matchStart < 0 {
+			break
+		}
+		matchStart, matchEnd = start+matchStart, start+matchEnd
+		if m.wordRegexp && !isWordBoundary(textBytes, matchStart, matchEnd) {
+			_, size := utf8.DecodeRune(textBytes[matchStart:])
+			start = matchStart + size
+			continue
+		}
+		locs = append(locs, []int{matchStart, matchEnd})
+		start = matchEnd
+	}
+	return locs
+}
+
+// index returns the first range of the pattern at patternIndex in textBytes,
+// or -1, -1 if it is not present.
+func (m *bytesMatcher) index(patternIndex int, textBytes []byte) (int, int) {
+	if m.foldPatterns[patternIndex] != nil {
+		return m.foldPatterns[patternIndex].index(textBytes)
+	}
+	p := m.bytesPatterns[patternIndex]
+	start := bytes.Index(textBytes, p)
+	if start < 0 {
+		return -1, -1
+	}
+	return start, start + len(p)
+}
+
+// isWordBoundary checks the characters around textBytes[start:end] are not
+// word characters.
+func isWordBoundary(textBytes []byte, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRune(textBytes[:start])
+		if isWordRune(r) {
+			return false
+		}
+	}
+	if end < len(textBytes) {
+		r, _ := utf8.DecodeRune(textBytes[end:])
+		if isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isWordRune returns true for letters, digits, combining marks and
+// underscore of any script.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+func (spans matchSpans) Len() int {
+	return len(spans)
+}
+
+func (spans matchSpans) Less(i, j int) bool {
+	return spans[i].Start < spans[j].Start
+}
+
+func (spans matchSpans) Swap(i, j int) {
+	spans[i], spans[j] = spans[j], spans[i]
 }
@@ -147,40 +147,226 @@ func TestBytesMatchIgnoreCase(t *testing.T) {
 	}
 }
 
-func TestBytesMatcherContainsIgnoreCase(t *testing.T) {
+func TestRegexpMatchSpans(t *testing.T) {
+	m := newRegexpMatcher(patternsForTest("fo+", "ba."), false)
+	spans := m.MatchSpans([]byte("bar foo baz fooo"))
+	expected := []MatchSpan{{0, 3, 1}, {4, 7, 0}, {8, 11, 1}, {12, 16, 0}}
+	if len(spans) != len(expected) {
+		t.Fatal("MatchSpans returns a wrong number of spans.", spans)
+	}
+	for i, span := range spans {
+		if span != expected[i] {
+			t.Error("MatchSpans returns a wrong span.", span, expected[i])
+		}
+	}
+	if m.MatchSpans([]byte("hoge")) != nil {
+		t.Error("MatchSpans should return nil when nothing matches.")
+	}
+}
+
+func TestBytesMatchSpans(t *testing.T) {
+	m := newBytesMatcher(patternsForTest("foo", "bar"), false)
+	spans := m.MatchSpans([]byte("barfoo Foo foofoo"))
+	expected := []MatchSpan{{0, 3, 1}, {3, 6, 0}, {11, 14, 0}, {14, 17, 0}}
+	if len(spans) != len(expected) {
+		t.Fatal("MatchSpans returns a wrong number of spans.", spans)
+	}
+	for i, span := range spans {
+		if span != expected[i] {
+			t.Error("MatchSpans returns a wrong span.", span, expected[i])
+		}
+	}
+}
+
+func TestBytesMatchSpansIgnoreCase(t *testing.T) {
 	m := newBytesMatcher(patternsForTest("foo"), true)
-	if !m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("foo")) {
-		t.Error("containsIgnoreCase should match text.")
+	spans := m.MatchSpans([]byte("xFoO foo"))
+	if len(spans) != 2 || spans[0] != (MatchSpan{1, 4, 0}) || spans[1] != (MatchSpan{5, 8, 0}) {
+		t.Error("MatchSpans should find text ignoring case.", spans)
+	}
+}
+
+func TestRegexpMatchWordRegexp(t *testing.T) {
+	m := newRegexpMatcherWithParams(&MatcherParams{Patterns: patternsForTest("fo+"), WordRegexp: true})
+	if !m.Match([]byte("foo")) || !m.Match([]byte("a foo.")) || !m.Match([]byte("foobar foo")) {
+		t.Error("Match for word regexp doesn't work.")
+	}
+	if m.Match([]byte("foobar")) || m.Match([]byte("_foo")) || m.Match([]byte("éfoo")) {
+		t.Error("Match for word regexp shouldn't match a part of word.")
+	}
+	spans := m.MatchSpans([]byte("foo foobar,fooo"))
+	if len(spans) != 2 || spans[0] != (MatchSpan{0, 3, 0}) || spans[1] != (MatchSpan{11, 15, 0}) {
+		t.Error("MatchSpans for word regexp returns wrong spans.", spans)
+	}
+
+	m = newRegexpMatcherWithParams(&MatcherParams{Patterns: patternsForTest("東京"), WordRegexp: true})
+	if !m.Match([]byte("東京 大阪")) || m.Match([]byte("東京都")) {
+		t.Error("Match for word regexp should handle Unicode word characters.")
+	}
+
+	m = newRegexpMatcherWithParams(&MatcherParams{Patterns: patternsForTest("foo|foobar"), WordRegexp: true})
+	spans = m.MatchSpans([]byte("foobar foo"))
+	if len(spans) != 2 || spans[0] != (MatchSpan{0, 6, 0}) || spans[1] != (MatchSpan{7, 10, 0}) {
+		t.Error("MatchSpans for word regexp should try other alternatives.", spans)
+	}
+
+	m = newRegexpMatcherWithParams(&MatcherParams{Patterns: patternsForTest("foo|-bar"), WordRegexp: true})
+	spans = m.MatchSpans([]byte("foo-bar"))
+	if len(spans) != 1 || spans[0] != (MatchSpan{0, 3, 0}) {
+		t.Error("MatchSpans for word regexp shouldn't match after a word character.", spans)
+	}
+	spans = m.MatchSpans([]byte("foo-bar -bar"))
+	if len(spans) != 2 || spans[1] != (MatchSpan{8, 12, 0}) {
+		t.Error("MatchSpans for word regexp should find a word after a skipped match.", spans)
+	}
+}
+
+func TestRegexpMatchLineRegexp(t *testing.T) {
+	m := newRegexpMatcherWithParams(&MatcherParams{Patterns: patternsForTest("foo|bar"), LineRegexp: true})
+	if !m.Match([]byte("foo")) || !m.Match([]byte("bar")) {
+		t.Error("Match for line regexp doesn't work.")
+	}
+	if m.Match([]byte("foo bar")) || m.Match([]byte("xfoo")) {
+		t.Error("Match for line regexp shouldn't match a part of line.")
+	}
+}
+
+func TestBytesMatchWordRegexp(t *testing.T) {
+	m := newBytesMatcherWithParams(&MatcherParams{Patterns: patternsForTest("a.b"), WordRegexp: true})
+	if !m.Match([]byte("a.b")) || !m.Match([]byte("x a.b-c")) || !m.Match([]byte("a.bc a.b")) {
+		t.Error("Match for word bytes doesn't work.")
+	}
+	if m.Match([]byte("a.bc")) || m.Match([]byte("ca.b")) || m.Match([]byte("a.bü")) {
+		t.Error("Match for word bytes shouldn't match a part of word.")
 	}
-	if !m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("Foo")) {
-		t.Error("containsIgnoreCase should match text.")
+	spans := m.MatchSpans([]byte("a.bc a.b"))
+	if len(spans) != 1 || spans[0] != (MatchSpan{5, 8, 0}) {
+		t.Error("MatchSpans for word bytes returns wrong spans.", spans)
 	}
-	if !m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("FOO")) {
-		t.Error("containsIgnoreCase should match text.")
+
+	m = newBytesMatcherWithParams(&MatcherParams{Patterns: patternsForTest("foo"), IgnoreCase: true, WordRegexp: true})
+	if !m.Match([]byte("xFoo FOO")) || m.Match([]byte("FOOx")) {
+		t.Error("Match for word bytes should work with ignore case.")
+	}
+}
+
+func TestBytesMatchLineRegexp(t *testing.T) {
+	m := newBytesMatcherWithParams(&MatcherParams{Patterns: patternsForTest("foo", "bar"), LineRegexp: true})
+	if !m.Match([]byte("foo")) || !m.Match([]byte("bar")) {
+		t.Error("Match for line bytes doesn't work.")
+	}
+	if m.Match([]byte("foo ")) || m.Match([]byte("Foo")) {
+		t.Error("Match for line bytes shouldn't match a part of line.")
 	}
-	if !m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("xFoOy")) {
-		t.Error("containsIgnoreCase should match text.")
+
+	m = newBytesMatcherWithParams(&MatcherParams{Patterns: patternsForTest("foo"), IgnoreCase: true, LineRegexp: true})
+	if !m.Match([]byte("FoO")) {
+		t.Error("Match for line bytes should work with ignore case.")
 	}
-	if !m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("FoFoOy")) {
-		t.Error("containsIgnoreCase should match text.")
+}
+
+func TestNewMatcher(t *testing.T) {
+	if _, ok := NewMatcher(&MatcherParams{Patterns: patternsForTest("fo+")}).(*regexpMatcher); !ok {
+		t.Error("NewMatcher should return a regexp matcher.")
 	}
-	if !m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("FoFoO")) {
-		t.Error("containsIgnoreCase should match text.")
+	if _, ok := NewMatcher(&MatcherParams{Patterns: patternsForTest("foo"), FixedStrings: true}).(*bytesMatcher); !ok {
+		t.Error("NewMatcher should return a bytes matcher for fixed strings.")
 	}
+}
 
-	if m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("")) {
-		t.Error("containsIgnoreCase should not match text.")
+func TestBytesMatchIgnoreCaseUnicode(t *testing.T) {
+	m := newBytesMatcher(patternsForTest("straße", "ǅ"), true)
+	if !m.Match([]byte("STRASSE STRAẞE")) || !m.Match([]byte("ǆ")) || !m.Match([]byte("Ǆ")) {
+		t.Error("Match for bytes should ignore case of Unicode text.")
 	}
-	if m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("F")) {
-		t.Error("containsIgnoreCase should not match text.")
+	spans := m.MatchSpans([]byte("Die STRAẞE"))
+	if len(spans) != 1 || spans[0] != (MatchSpan{4, 12, 0}) {
+		t.Error("MatchSpans for bytes should return ranges of Unicode text.", spans)
+	}
+}
+
+func TestRegexpMatchIgnoreCaseCorpus(t *testing.T) {
+	corpus := []struct {
+		pattern string
+		text    string
+		match   bool
+	}{
+		{"[a-c]+x", "ABCX", true},
+		{"[^a-z]", "abc", false},
+		{"[^a-z]", "ABC", false},
+		{"\\d+px", "12PX", true},
+		{"\\D", "123", false},
+		{"\\w+", "FOO", true},
+		{"\\Sfoo", " FOO", false},
+		{"\\.txt$", "README.TXT", true},
+		{"\\.txt$", "READMEXTXT", false},
+		{"a{2}b", "AaB", true},
+		{"(foo|bar)baz", "BARBAZ", true},
+		{"\\pL+", "ÉCOLE", true},
+		{"σίσυφος", "ΣΊΣΥΦΟΣ", true},
+		{"σ", "ς", true},
+		{"москва", "МОСКВА", true},
+		{"[а-я]+", "ПРИВЕТ", true},
+		{"ｇｏｐｈｅｒ", "ＧＯＰＨＥＲ", true},
+		{"[ａ-ｚ]", "Ｚ", true},
+		{"東京", "東京", true},
+		{"東京", "大阪", false},
+	}
+	for _, c := range corpus {
+		m := newRegexpMatcher(patternsForTest(c.pattern), true)
+		if m.Match([]byte(c.text)) != c.match {
+			t.Error("Match for regexp ignoring case returns a wrong result.", c.pattern, c.text)
+		}
 	}
-	if m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("Fo")) {
-		t.Error("containsIgnoreCase should not match text.")
+}
+
+func TestRegexpMatchIgnoreCaseLineRegexp(t *testing.T) {
+	m := newRegexpMatcherWithParams(&MatcherParams{
+		Patterns: patternsForTest("foo|bar"), IgnoreCase: true, LineRegexp: true})
+	if !m.Match([]byte("BAR")) || m.Match([]byte("FOOBAR")) {
+		t.Error("Match for line regexp should ignore case.")
 	}
-	if m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("FoF")) {
-		t.Error("containsIgnoreCase should not match text.")
+}
+
+func TestMatchIgnoreCases(t *testing.T) {
+	params := &MatcherParams{Patterns: patternsForTest("foo", "Bar"), IgnoreCases: []bool{true, false}}
+	for _, fixedStrings := range []bool{false, true} {
+		params.FixedStrings = fixedStrings
+		m := NewMatcher(params)
+		if !m.Match([]byte("FOO")) || !m.Match([]byte("Bar")) {
+			t.Error("Match should use case sensitivity of each pattern.", fixedStrings)
+		}
+		if m.Match([]byte("bar")) || m.Match([]byte("BAR")) {
+			t.Error("Match shouldn't ignore case of a case sensitive pattern.", fixedStrings)
+		}
 	}
-	if m.containsIgnoreCase([]byte("foo"), []byte("FOO"), []byte("FoFo")) {
-		t.Error("containsIgnoreCase should not match text.")
+}
+
+func TestBytesMatcherAhoCorasickIgnoreCases(t *testing.T) {
+	patterns := make([]string, ahoCorasickPatternThreshold)
+	ignoreCases := make([]bool, len(patterns))
+	for i := range patterns {
+		patterns[i] = "foo" + string(rune('a'+i))
+		ignoreCases[i] = true
+	}
+	m := newBytesMatcherWithParams(&MatcherParams{Patterns: patterns, IgnoreCases: ignoreCases})
+	if m.foldAutomaton == nil || !m.Match([]byte("FOOA")) {
+		t.Error("Aho-Corasick should be used when patterns ignore case.")
+	}
+	ignoreCases[0] = false
+	m = newBytesMatcherWithParams(&MatcherParams{Patterns: patterns, IgnoreCases: ignoreCases})
+	if m.automaton == nil || m.foldAutomaton == nil || m.Match([]byte("FOOA")) || !m.Match([]byte("FOOB")) {
+		t.Error("Aho-Corasick should be used when case sensitivities are mixed.")
+	}
+	spans := m.MatchSpans([]byte("fooa FOOB"))
+	if len(spans) != 2 || spans[0] != (MatchSpan{0, 4, 0}) || spans[1] != (MatchSpan{5, 9, 1}) {
+		t.Error("MatchSpans should return pattern indexes of both automata.", spans)
+	}
+	for i := range ignoreCases {
+		ignoreCases[i] = false
+	}
+	m = newBytesMatcherWithParams(&MatcherParams{Patterns: patterns, IgnoreCases: ignoreCases})
+	if m.automaton == nil || m.Match([]byte("FOOA")) || !m.Match([]byte("fooa")) {
+		t.Error("Aho-Corasick should be used when no pattern ignores case.")
 	}
 }
@@ -0,0 +1,173 @@
+package book
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+)
+
+// multilineFinder searches patterns over lines joined by '\n'. It keeps
+// three pages and searches a window of the current and the next page, so a
+// match can span a page boundary. A match which starts in the current page
+// is reported with the range of lines it covers.
+type multilineFinder struct {
+	findParams  *FindParams
+	foundParams FoundParams
+	matcher     Matcher
+	input       Input
+
+	previousPage Page
+	currentPage  Page
+	nextPage     Page
+
+	lineNum LineNumber
+	// The last line number reported to the handler. It starts at 1.
+	lastFoundLineNumber LineNumber
+	count               FoundCountType
+
+	window     bytes.Buffer
+	lineStarts []int
+}
+
+func newMultilineFinder(file string, findParams *FindParams) (f *multilineFinder) {
+	f = new(multilineFinder)
+	f.findParams = findParams
+
+	patterns := make([]string, len(findParams.Patterns))
+	for i, p := range findParams.Patterns {
+		if findParams.FixedStrings {
+			p = regexp.QuoteMeta(p)
+		}
+		// ^ and $ of LineRegexp match at line boundaries in the window.
+		if findParams.LineRegexp {
+			p = "^(?:" + p + ")$"
+		}
+		patterns[i] = "(?m:" + p + ")"
+	}
+	f.matcher = newRegexpMatcherWithParams(&MatcherParams{
+		Patterns:     patterns,
+		IgnoreCase:   findParams.IgnoreCase,
+		IgnoreCases:  findParams.IgnoreCases,
+		WordRegexp:   findParams.WordRegexp && !findParams.LineRegexp,
+		LongestMatch: findParams.LongestMatch,
+	})
+
+	f.foundParams.FindParams = *findParams
+	f.foundParams.file = file
+
+	f.previousPage = NewPage(&PageParams{Capacity: pageCapacity})
+	f.currentPage = NewPage(&PageParams{Capacity: pageCapacity})
+	f.nextPage = NewPage(&PageParams{Capacity: pageCapacity})
+	f.previousPage.Next(f.currentPage)
+	f.currentPage.Next(f.nextPage)
+	return
+}
+
+func (c *chapter) findMultiline(findParams *FindParams) FoundCountType {
+	f := newMultilineFinder(c.file, findParams)
+
+	input, _ := c.createInput()
+	defer input.Close()
+	f.input = input
+
+	input.EachLineBytes(f.addLineBytes)
+	f.flush()
+
+	return f.count
+}
+
+func (f *multilineFinder) addLineBytes(line []byte) {
+	if !f.nextPage.IsEnoughCapacity() {
+		f.search()
+		f.rotate()
+	}
+	f.nextPage.AddLineBytes(line)
+	f.lineNum++
+}
+
+func (f *multilineFinder) flush() {
+	f.search()
+	f.rotate()
+	f.search()
+}
+
+// rotate makes the next page current and reuses the previous page as the
+// next page.
+func (f *multilineFinder) rotate() {
+	reused := f.previousPage
+	f.previousPage = f.currentPage
+	f.currentPage = f.nextPage
+	f.nextPage = reused
+
+	f.nextPage.Reset()
+	f.nextPage.SetStartLineNumber(f.lineNum)
+	f.previousPage.Next(f.currentPage)
+	f.currentPage.Next(f.nextPage)
+}
+
+// search reports matches which start in the current page.
+func (f *multilineFinder) search() {
+	currentLen := f.currentPage.Length()
+	if currentLen == 0 || f.reachedMaxCount() {
+		return
+	}
+
+	f.window.Reset()
+	f.lineStarts = f.lineStarts[:0]
+	for _, page := range []Page{f.currentPage, f.nextPage} {
+		for i := 0; i < page.Length(); i++ {
+			if len(f.lineStarts) > 0 {
+				f.window.WriteByte('\n')
+			}
+			f.lineStarts = append(f.lineStarts, f.window.Len())
+			f.window.Write(page.LineBytesAt(i))
+		}
+	}
+
+	text := f.window.Bytes()
+	for _, span := range nonOverlappingSpans(f.matcher.MatchSpans(text)) {
+		first := f.lineIndexAt(span.Start)
+		if first >= currentLen {
+			break
+		}
+		last := f.lineIndexAt(span.End - 1)
+
+		startLineNumber := f.currentPage.StartLineNumber() + lineNumberStartAt
+		if startLineNumber+LineNumber(first) <= f.lastFoundLineNumber {
+			first = int(f.lastFoundLineNumber - startLineNumber + 1)
+		}
+		if first > last {
+			continue
+		}
+		f.lastFoundLineNumber = startLineNumber + LineNumber(last)
+		f.count += FoundCountType(last - first + 1)
+
+		if f.findParams.Handler != nil {
+			f.foundParams.page = f.currentPage
+			f.foundParams.linePosInPage = first
+			if first >= currentLen {
+				f.foundParams.page = f.nextPage
+				f.foundParams.linePosInPage = first - currentLen
+			}
+			f.foundParams.followingMatchLines = last - first
+			f.foundParams.lineNumber = startLineNumber + LineNumber(first)
+			f.findParams.Handler(&f.foundParams)
+		}
+		if f.reachedMaxCount() {
+			f.input.Stop()
+			return
+		}
+	}
+}
+
+func (f *multilineFinder) reachedMaxCount() bool {
+	return f.findParams.MaxCount > 0 && f.count >= FoundCountType(f.findParams.MaxCount)
+}
+
+// lineIndexAt returns the index of the line in the window which contains
+// the offset.
+func (f *multilineFinder) lineIndexAt(offset int) int {
+	return sort.Search(len(f.lineStarts), func(i int) bool {
+		return f.lineStarts[i] > offset
+	}) - 1
+}
@@ -0,0 +1,129 @@
+package book
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type foundLinesForTest struct {
+	lineNumbers []LineNumber
+	paragraphs  []string
+}
+
+func (f *foundLinesForTest) handler(params *FoundParams) {
+	f.lineNumbers = append(f.lineNumbers, params.lineNumber)
+	lines := make([]string, 0)
+	for _, line := range params.paragraph() {
+		if line != nil {
+			lines = append(lines, string(line))
+		}
+	}
+	f.paragraphs = append(f.paragraphs, strings.Join(lines, "|"))
+}
+
+func TestFindMultiline(t *testing.T) {
+	c := newChapterBytes([]byte("func a() {\n\tpanic(1)\n}\nfunc b() {\n\treturn\n}\n"))
+	defer c.Close()
+	found := new(foundLinesForTest)
+	count := c.Find(&FindParams{
+		Patterns:  []string{`func .*\n\s*panic`},
+		Multiline: true,
+		Handler:   found.handler,
+	})
+	if count != 2 {
+		t.Error("Find should count all matched lines.", count)
+	}
+	if len(found.lineNumbers) != 1 || found.lineNumbers[0] != 1 || found.paragraphs[0] != "func a() {|\tpanic(1)" {
+		t.Error("Find should report a range of matched lines.", found)
+	}
+}
+
+func TestFindMultilineContext(t *testing.T) {
+	c := newChapterBytes([]byte("a\nb\nc\nd\ne\nf\n"))
+	defer c.Close()
+	found := new(foundLinesForTest)
+	c.Find(&FindParams{
+		Patterns:            []string{"c\nd", "d"},
+		Multiline:           true,
+		BeforeContextLength: 1,
+		AfterContextLength:  1,
+		Handler:             found.handler,
+	})
+	if len(found.paragraphs) != 1 || found.paragraphs[0] != "b|c|d|e" {
+		t.Error("Find should print context lines around matched lines.", found)
+	}
+}
+
+func TestFindMultilineLineRegexp(t *testing.T) {
+	c := newChapterBytes([]byte("a\nb\nc\nxb\nc\n"))
+	defer c.Close()
+	found := new(foundLinesForTest)
+	count := c.Find(&FindParams{
+		Patterns:   []string{"b\nc"},
+		Multiline:  true,
+		LineRegexp: true,
+		Handler:    found.handler,
+	})
+	if count != 2 || len(found.lineNumbers) != 1 || found.lineNumbers[0] != 2 {
+		t.Error("LineRegexp should match whole lines in the middle of input.", count, found)
+	}
+}
+
+func TestFindMultilineOverPages(t *testing.T) {
+	lines := make([]string, pageCapacity*3)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line%d", i)
+	}
+	lines[pageCapacity-1] = "begin"
+	lines[pageCapacity] = "end"
+	lines[pageCapacity*2+10] = "begin"
+	lines[pageCapacity*2+11] = "end"
+	c := newChapterBytes([]byte(strings.Join(lines, "\n")))
+	defer c.Close()
+	found := new(foundLinesForTest)
+	count := c.Find(&FindParams{
+		Patterns:     []string{"begin\nend"},
+		FixedStrings: true,
+		Multiline:    true,
+		Handler:      found.handler,
+	})
+	if count != 4 {
+		t.Error("Find should find matches over pages.", count)
+	}
+	if len(found.lineNumbers) != 2 || found.lineNumbers[0] != pageCapacity || found.lineNumbers[1] != pageCapacity*2+11 {
+		t.Error("Find should report correct line numbers.", found.lineNumbers)
+	}
+	if len(found.paragraphs) != 2 || found.paragraphs[0] != "begin|end" || found.paragraphs[1] != "begin|end" {
+		t.Error("Find should report matched lines over pages.", found.paragraphs)
+	}
+}
+
+func TestFindMultilineSameLine(t *testing.T) {
+	c := newChapterBytes([]byte("foo foo\nfoo\n"))
+	defer c.Close()
+	found := new(foundLinesForTest)
+	count := c.Find(&FindParams{
+		Patterns:  []string{"foo"},
+		Multiline: true,
+		Handler:   found.handler,
+	})
+	if count != 2 || len(found.lineNumbers) != 2 || found.lineNumbers[1] != 2 {
+		t.Error("Find should report each line once.", count, found.lineNumbers)
+	}
+}
+
+func TestFindMultilineMaxCount(t *testing.T) {
+	c := newChapterBytes([]byte(strings.Repeat("a\nb\n", 3000)))
+	defer c.Close()
+	found := new(foundLinesForTest)
+	count := c.Find(&FindParams{
+		Patterns:  []string{`a\nb`},
+		Multiline: true,
+		MaxCount:  4,
+		Handler:   found.handler,
+	})
+	if count != 4 || len(found.lineNumbers) != 2 || found.lineNumbers[1] != 3 {
+		t.Error("Find should stop after MaxCount selected lines.", count, found.lineNumbers)
+	}
+}
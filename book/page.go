@@ -1,6 +1,7 @@
 package book
 
 import (
+	"sync/atomic"
 //	"io"
 //	"bytes"
 )
@@ -25,8 +26,9 @@ type page struct {
 	// This is pooled buffer for slice referenced by lines
 	pools []LineBytes
 	lines [][]byte
-	// This is filled length of lines
-	length int
+	// This is filled length of lines. It is read by the search of the
+	// previous page while lines are added.
+	length int32
 	// This is the same as len(pools)
 	capacity int
 
@@ -72,7 +74,6 @@ func newPage(params *PageParams) *page {
 		first += initialBufferSize
 	}
 
-	p.length = 0
 	p.capacity = params.Capacity
 	p.startLineNumber = params.StartLineNumber
 	return p
@@ -80,27 +81,28 @@ func newPage(params *PageParams) *page {
 
 func (p *page) AddLineBytes(line LineBytes) {
 	ln := len(line)
-	if cap(p.pools[p.length]) < ln {
-		p.pools[p.length] = make(LineBytes, ln<<1) // TODO: Check this incremented size is ok ??
+	length := p.Length()
+	if cap(p.pools[length]) < ln {
+		p.pools[length] = make(LineBytes, ln<<1) // TODO: Check this incremented size is ok ??
 	}
-	p.lines[p.length] = p.pools[p.length][0:ln]
-	copy(p.lines[p.length], line)
-	p.length++
+	p.lines[length] = p.pools[length][0:ln]
+	copy(p.lines[length], line)
+	atomic.AddInt32(&p.length, 1)
 }
 
 func (p *page) IsEnoughCapacity() bool {
-	return p.length < p.capacity
+	return p.Length() < p.capacity
 }
 
 func (p *page) Reset() {
-	p.length = 0
+	atomic.StoreInt32(&p.length, 0)
 	p.nextPage = nil
 	p.previousPage = nil
 	p.startLineNumber = 0
 }
 
 func (p *page) Length() int {
-	return p.length
+	return int(atomic.LoadInt32(&p.length))
 }
 
 func (p *page) Capacity() int {
@@ -130,17 +132,23 @@ func (p *page) LineBytesBeforeAndAfter(index, beforeLength, afterLength int) []L
 			paragraph[beforeLength-i] = p.LineBytesAt(index - i)
 		} else if p.previousPage != nil {
 			n := p.previousPage.Length() + (index - i)
-			paragraph[beforeLength-i] = p.previousPage.LineBytesAt(n)
+			if n >= 0 {
+				paragraph[beforeLength-i] = p.previousPage.LineBytesAt(n)
+			}
 		}
 	}
 
+	length := p.Length()
 	for i := 1; i <= afterLength; i++ {
-		if index+i < p.length {
+		if index+i < length {
 			paragraph[beforeLength+i] = p.LineBytesAt(index + i)
 		} else if p.nextPage != nil {
 			// How we can check nextPage is valid or not ?
 			// This will be handled by a caller(main) side.
-			paragraph[beforeLength+i] = p.nextPage.LineBytesAt((index + i) - p.length)
+			n := (index + i) - length
+			if n < p.nextPage.Length() {
+				paragraph[beforeLength+i] = p.nextPage.LineBytesAt(n)
+			}
 		}
 	}
 
@@ -0,0 +1,334 @@
+package book
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var posixClassNames = []string{
+	"alnum", "alpha", "blank", "cntrl", "digit", "graph",
+	"lower", "print", "punct", "space", "upper", "xdigit",
+}
+
+// posixTranslator converts POSIX basic or extended regular expressions to
+// Go regexp syntax.
+type posixTranslator struct {
+	pattern string
+	pos     int
+	basic   bool
+	buffer  bytes.Buffer
+
+	// Output position of the last atom which a repetition applies to. It
+	// is -1 when there is no atom, e.g. at the beginning of a group.
+	atomStart int
+	// The last output is a repetition operator.
+	repeated bool
+	// Output positions of open groups.
+	groupStarts []int
+}
+
+// TranslateBasicRegexp converts a POSIX basic regular expression (BRE) to
+// Go regexp syntax. GNU extensions \+, \?, \|, \w, \W, \s, \S, \b and \B
+// are supported. Backreferences, \< and \> are not supported.
+//
+// e.g.
+// TranslateBasicRegexp(`\(ab\)\{2\}+`) returns `(ab){2}\+`
+func TranslateBasicRegexp(pattern string) (string, error) {
+	t := &posixTranslator{pattern: pattern, basic: true}
+	return t.translate()
+}
+
+// TranslateExtendedRegexp converts a POSIX extended regular expression
+// (ERE) to Go regexp syntax. Backreferences, \< and \> are not supported.
+func TranslateExtendedRegexp(pattern string) (string, error) {
+	t := &posixTranslator{pattern: pattern, basic: false}
+	return t.translate()
+}
+
+func (t *posixTranslator) translate() (string, error) {
+	t.atomStart = -1
+	for t.pos < len(t.pattern) {
+		r, size := utf8.DecodeRuneInString(t.pattern[t.pos:])
+		t.pos += size
+
+		var err error
+		switch {
+		case r == '\\':
+			err = t.translateEscape()
+		case r == '[':
+			err = t.translateBracket()
+		case r == '.':
+			t.writeAtom(".")
+		case r == '*':
+			t.writeRepetition("*")
+		case r == '^':
+			t.translateCaret()
+		case r == '$':
+			t.translateDollar()
+		case !t.basic && r == '(':
+			t.openGroup()
+		case !t.basic && r == ')':
+			err = t.closeGroup()
+		case !t.basic && r == '|':
+			t.writeAlternation()
+		case !t.basic && (r == '+' || r == '?'):
+			t.writeRepetition(string(r))
+		case !t.basic && r == '{':
+			t.translateInterval()
+		default:
+			t.writeAtom(regexp.QuoteMeta(string(r)))
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(t.groupStarts) > 0 {
+		return "", errors.New("unmatched ( or \\(")
+	}
+	return t.buffer.String(), nil
+}
+
+func (t *posixTranslator) translateEscape() error {
+	if t.pos >= len(t.pattern) {
+		return errors.New("trailing backslash (\\)")
+	}
+	r, size := utf8.DecodeRuneInString(t.pattern[t.pos:])
+	t.pos += size
+
+	switch {
+	case '1' <= r && r <= '9':
+		return fmt.Errorf("backreference \\%c is not supported", r)
+	case r == '<' || r == '>':
+		return fmt.Errorf("word boundary \\%c is not supported", r)
+	case r == 'w' || r == 'W' || r == 's' || r == 'S':
+		t.writeAtom("\\" + string(r))
+	case r == 'b' || r == 'B':
+		t.buffer.WriteString("\\" + string(r))
+		t.atomStart = -1
+	case r == '`':
+		t.buffer.WriteString("\\A")
+		t.atomStart = -1
+	case r == '\'':
+		t.buffer.WriteString("\\z")
+		t.atomStart = -1
+	case t.basic && r == '(':
+		t.openGroup()
+	case t.basic && r == ')':
+		return t.closeGroup()
+	case t.basic && r == '|':
+		t.writeAlternation()
+	case t.basic && (r == '+' || r == '?'):
+		t.writeRepetition(string(r))
+	case t.basic && r == '{':
+		return t.translateBasicInterval()
+	default:
+		t.writeAtom(regexp.QuoteMeta(string(r)))
+	}
+	return nil
+}
+
+// translateBracket converts a bracket expression. A backslash is a literal
+// character in it and ']' is literal at the beginning.
+func (t *posixTranslator) translateBracket() error {
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	if strings.HasPrefix(t.pattern[t.pos:], "^") {
+		buffer.WriteString("^")
+		t.pos++
+	}
+	if strings.HasPrefix(t.pattern[t.pos:], "]") {
+		buffer.WriteString("\\]")
+		t.pos++
+	}
+
+	for t.pos < len(t.pattern) {
+		rest := t.pattern[t.pos:]
+		switch {
+		case rest[0] == ']':
+			t.pos++
+			buffer.WriteString("]")
+			t.writeAtom(buffer.String())
+			return nil
+		case strings.HasPrefix(rest, "[:"):
+			end := strings.Index(rest, ":]")
+			if end < 0 {
+				return errors.New("unmatched [:")
+			}
+			name := rest[2:end]
+			if !isPosixClassName(name) {
+				return fmt.Errorf("invalid character class [:%s:]", name)
+			}
+			buffer.WriteString(rest[:end+2])
+			t.pos += end + 2
+		case strings.HasPrefix(rest, "[.") || strings.HasPrefix(rest, "[="):
+			end := strings.Index(rest[2:], string(rest[1])+"]")
+			if end < 0 {
+				return fmt.Errorf("unmatched %s", rest[:2])
+			}
+			element := rest[2 : 2+end]
+			if utf8.RuneCountInString(element) != 1 {
+				return fmt.Errorf("collating element %s%s%c] is not supported", rest[:2], element, rest[1])
+			}
+			buffer.WriteString(regexp.QuoteMeta(element))
+			if element == "-" {
+				buffer.Truncate(buffer.Len() - 1)
+				buffer.WriteString("\\-")
+			}
+			t.pos += end + 4
+		default:
+			r, size := utf8.DecodeRuneInString(rest)
+			if r == '\\' || r == '[' {
+				buffer.WriteString("\\")
+			}
+			buffer.WriteRune(r)
+			t.pos += size
+		}
+	}
+	return errors.New("unmatched [")
+}
+
+func (t *posixTranslator) translateCaret() {
+	if t.basic && !t.atBeginning() {
+		t.writeAtom("\\^")
+		return
+	}
+	t.buffer.WriteString("^")
+	t.atomStart = -1
+	t.repeated = false
+}
+
+func (t *posixTranslator) translateDollar() {
+	if t.basic && !t.atEnd() {
+		t.writeAtom("\\$")
+		return
+	}
+	t.buffer.WriteString("$")
+	t.atomStart = -1
+	t.repeated = false
+}
+
+// atBeginning checks the current position is at the beginning of a basic
+// regexp, a group or an alternative.
+func (t *posixTranslator) atBeginning() bool {
+	start := t.pos - 1
+	return start == 0 || strings.HasSuffix(t.pattern[:start], "\\(") || strings.HasSuffix(t.pattern[:start], "\\|")
+}
+
+// atEnd checks the current position is at the end of a basic regexp, a
+// group or an alternative.
+func (t *posixTranslator) atEnd() bool {
+	rest := t.pattern[t.pos:]
+	return rest == "" || strings.HasPrefix(rest, "\\)") || strings.HasPrefix(rest, "\\|")
+}
+
+// translateInterval converts {m,n} of extended regexp. It is a literal '{'
+// if it isn't a valid interval.
+func (t *posixTranslator) translateInterval() {
+	end := strings.IndexByte(t.pattern[t.pos:], '}')
+	if end < 0 || t.atomStart < 0 || !isPosixInterval(t.pattern[t.pos:t.pos+end]) {
+		t.writeAtom("\\{")
+		return
+	}
+	t.writeRepetition(intervalOperator(t.pattern[t.pos : t.pos+end]))
+	t.pos += end + 1
+}
+
+// translateBasicInterval converts \{m,n\} of basic regexp.
+func (t *posixTranslator) translateBasicInterval() error {
+	end := strings.Index(t.pattern[t.pos:], "\\}")
+	if end < 0 {
+		return errors.New("unmatched \\{")
+	}
+	interval := t.pattern[t.pos : t.pos+end]
+	if !isPosixInterval(interval) {
+		return fmt.Errorf("invalid interval \\{%s\\}", interval)
+	}
+	t.writeRepetition(intervalOperator(interval))
+	t.pos += end + 2
+	return nil
+}
+
+func (t *posixTranslator) openGroup() {
+	t.groupStarts = append(t.groupStarts, t.buffer.Len())
+	t.buffer.WriteString("(")
+	t.atomStart = -1
+	t.repeated = false
+}
+
+func (t *posixTranslator) closeGroup() error {
+	n := len(t.groupStarts)
+	if n == 0 {
+		return errors.New("unmatched ) or \\)")
+	}
+	t.buffer.WriteString(")")
+	t.atomStart = t.groupStarts[n-1]
+	t.groupStarts = t.groupStarts[:n-1]
+	t.repeated = false
+	return nil
+}
+
+func (t *posixTranslator) writeAlternation() {
+	t.buffer.WriteString("|")
+	t.atomStart = -1
+	t.repeated = false
+}
+
+func (t *posixTranslator) writeAtom(atom string) {
+	t.atomStart = t.buffer.Len()
+	t.buffer.WriteString(atom)
+	t.repeated = false
+}
+
+// writeRepetition writes a repetition operator. It is a literal character
+// when there is no atom before it. A repeated atom is grouped before
+// adding another repetition because Go regexp doesn't allow it (e.g. a**).
+func (t *posixTranslator) writeRepetition(operator string) {
+	if t.atomStart < 0 {
+		t.writeAtom(regexp.QuoteMeta(operator))
+		return
+	}
+	if t.repeated {
+		repeated := t.buffer.String()[t.atomStart:]
+		t.buffer.Truncate(t.atomStart)
+		t.buffer.WriteString("(?:" + repeated + ")")
+	}
+	t.buffer.WriteString(operator)
+	t.repeated = true
+}
+
+func isPosixClassName(name string) bool {
+	for _, className := range posixClassNames {
+		if name == className {
+			return true
+		}
+	}
+	return false
+}
+
+// isPosixInterval checks text is "m", "m,", "m,n" or ",n" without braces.
+func isPosixInterval(text string) bool {
+	parts := strings.Split(text, ",")
+	if len(parts) > 2 || text == "," || text == "" {
+		return false
+	}
+	for _, part := range parts {
+		for _, r := range part {
+			if r < '0' || '9' < r {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func intervalOperator(interval string) string {
+	if strings.HasPrefix(interval, ",") {
+		interval = "0" + interval
+	}
+	return "{" + interval + "}"
+}
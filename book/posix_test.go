@@ -0,0 +1,149 @@
+package book
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestTranslateBasicRegexp(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{`a.c`, `a.c`},
+		{`\(ab\)\{2\}`, `(ab){2}`},
+		{`a\{1,\}`, `a{1,}`},
+		{`a\{,3\}`, `a{0,3}`},
+		{`a+b?(c)|{d}`, `a\+b\?\(c\)\|\{d\}`},
+		{`a\+b\?`, `a+b?`},
+		{`foo\|bar`, `foo|bar`},
+		{`*a`, `\*a`},
+		{`\(*a\)`, `(\*a)`},
+		{`^*a`, `^\*a`},
+		{`a^b$c`, `a\^b\$c`},
+		{`^a$`, `^a$`},
+		{`\(^a$\)\|^b$`, `(^a$)|^b$`},
+		{`a**`, `(?:a*)*`},
+		{`\.\*`, `\.\*`},
+		{`\w\+`, `\w+`},
+	}
+	for _, test := range tests {
+		translated, err := TranslateBasicRegexp(test.pattern)
+		if err != nil || translated != test.expected {
+			t.Error("TranslateBasicRegexp should convert a pattern.", test.pattern, translated, err)
+		}
+		if _, err := regexp.Compile(translated); err != nil {
+			t.Error("Translated pattern should be compiled.", translated, err)
+		}
+	}
+}
+
+func TestTranslateExtendedRegexp(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{`(ab){2}`, `(ab){2}`},
+		{`a{,3}`, `a{0,3}`},
+		{`a{x}`, `a\{x\}`},
+		{`{1}a`, `\{1\}a`},
+		{`a+?`, `(?:a+)?`},
+		{`+a`, `\+a`},
+		{`(*a|?b)`, `(\*a|\?b)`},
+		{`a^b$`, `a^b$`},
+		{`\(\)\{`, `\(\)\{`},
+	}
+	for _, test := range tests {
+		translated, err := TranslateExtendedRegexp(test.pattern)
+		if err != nil || translated != test.expected {
+			t.Error("TranslateExtendedRegexp should convert a pattern.", test.pattern, translated, err)
+		}
+		if _, err := regexp.Compile(translated); err != nil {
+			t.Error("Translated pattern should be compiled.", translated, err)
+		}
+	}
+}
+
+func TestTranslatePosixBracket(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{`[]a]`, `[\]a]`},
+		{`[^]a]`, `[^\]a]`},
+		{`[a\]`, `[a\\]`},
+		{`[[:alpha:][:digit:]_]`, `[[:alpha:][:digit:]_]`},
+		{`[a-z[]`, `[a-z\[]`},
+		{`[[.-.]a]`, `[\-a]`},
+		{`[[=e=]]`, `[e]`},
+		{`[*]*`, `[*]*`},
+	}
+	for _, test := range tests {
+		translated, err := TranslateExtendedRegexp(test.pattern)
+		if err != nil || translated != test.expected {
+			t.Error("Bracket expression should be converted.", test.pattern, translated, err)
+		}
+	}
+
+	r := regexp.MustCompile(mustTranslateBasicRegexp(t, `[a\]`))
+	if !r.MatchString(`\`) || r.MatchString("]") {
+		t.Error("Backslash should be literal in bracket expression.")
+	}
+}
+
+func TestTranslatePosixRegexpError(t *testing.T) {
+	for _, pattern := range []string{`\(a\)\1`, `\<a`, `a\>`, `[[:foo:]]`, `[[.ch.]]`, `[a`, `\(a`, `a\)`, `a\`, `a\{1`, `a\{x\}`} {
+		if _, err := TranslateBasicRegexp(pattern); err == nil {
+			t.Error("TranslateBasicRegexp should return an error.", pattern)
+		}
+	}
+	for _, pattern := range []string{`(a)\1`, `(a`, `a)`} {
+		if _, err := TranslateExtendedRegexp(pattern); err == nil {
+			t.Error("TranslateExtendedRegexp should return an error.", pattern)
+		}
+	}
+}
+
+func TestTranslatePosixRegexpMatch(t *testing.T) {
+	r := regexp.MustCompile(mustTranslateBasicRegexp(t, `^a*b\{2\}c+$`))
+	if !r.MatchString("aabbc+") || r.MatchString("aabbcc") {
+		t.Error("Translated basic regexp should match like grep -G.")
+	}
+}
+
+func TestFindOnlyMatchingLongestMatch(t *testing.T) {
+	pattern, err := TranslateExtendedRegexp(`a|ab`)
+	if err != nil {
+		t.Fatal("TranslateExtendedRegexp failed.", err)
+	}
+	c := newChapterBytes([]byte("ab\n"))
+	defer c.Close()
+	for _, longestMatch := range []bool{false, true} {
+		var parts []string
+		c.Find(&FindParams{
+			Patterns:     []string{pattern},
+			LongestMatch: longestMatch,
+			Handler: func(params *FoundParams) {
+				line := params.LineBytes()
+				for _, span := range nonOverlappingSpans(params.MatchSpans()) {
+					parts = append(parts, string(line[span.Start:span.End]))
+				}
+			},
+		})
+		expected := "a"
+		if longestMatch {
+			expected = "ab"
+		}
+		if len(parts) != 1 || parts[0] != expected {
+			t.Error("-o should print the leftmost longest match of POSIX regexps.", longestMatch, parts)
+		}
+	}
+}
+
+func mustTranslateBasicRegexp(t *testing.T, pattern string) string {
+	translated, err := TranslateBasicRegexp(pattern)
+	if err != nil {
+		t.Fatal("TranslateBasicRegexp failed.", err)
+	}
+	return translated
+}
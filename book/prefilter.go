@@ -0,0 +1,122 @@
+package book
+
+import (
+	"bytes"
+	"regexp/syntax"
+)
+
+// literalPrefilter skips lines which don't contain a literal required by a
+// regexp pattern, so the regexp only runs for candidate lines.
+// e.g.
+// A line matching `ERROR.*timeout` must contain "timeout".
+type literalPrefilter struct {
+	literal     []byte
+	foldPattern *foldPattern
+}
+
+// newLiteralPrefilter returns nil if pattern has no required literal.
+func newLiteralPrefilter(pattern string) *literalPrefilter {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	literal, fold := requiredLiteral(re)
+	if len(literal) == 0 {
+		return nil
+	}
+
+	f := new(literalPrefilter)
+	f.literal = []byte(literal)
+	if fold {
+		f.foldPattern = newFoldPattern(f.literal)
+	}
+	return f
+}
+
+// contains checks textBytes contains the required literal.
+func (f *literalPrefilter) contains(textBytes []byte) bool {
+	if f.foldPattern != nil {
+		start, _ := f.foldPattern.index(textBytes)
+		return start >= 0
+	}
+	return bytes.Contains(textBytes, f.literal)
+}
+
+// requiredLiteral returns the longest literal which every match of re
+// contains, and whether it is compared ignoring case. Adjacent literals in a
+// concatenation are joined.
+func requiredLiteral(re *syntax.Regexp) (string, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune), isFoldLiteral(re)
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		longest, longestFold := "", false
+		run, runFold := "", false
+		choose := func(literal string, fold bool) {
+			if len(literal) > len(longest) {
+				longest, longestFold = literal, fold
+			}
+		}
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral && (run == "" || runFold == isFoldLiteral(sub)) {
+				run, runFold = run+string(sub.Rune), isFoldLiteral(sub)
+				continue
+			}
+			choose(run, runFold)
+			run, runFold = "", false
+			if sub.Op == syntax.OpLiteral {
+				run, runFold = string(sub.Rune), isFoldLiteral(sub)
+			} else {
+				choose(requiredLiteral(sub))
+			}
+		}
+		choose(run, runFold)
+		return longest, longestFold
+	}
+	return "", false
+}
+
+// pureLiteral returns the literal if pattern only matches the literal. It
+// returns false if pattern has operators or assertions.
+func pureLiteral(pattern string) (string, bool, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil || re.Op != syntax.OpLiteral {
+		return "", false, false
+	}
+	return string(re.Rune), isFoldLiteral(re), true
+}
+
+// literalMatcherParams converts regexp params to params of a bytesMatcher
+// if all patterns are pure literals. Otherwise, it returns nil.
+func literalMatcherParams(params *MatcherParams) *MatcherParams {
+	m := matcher{ignoreCase: params.IgnoreCase, ignoreCases: params.IgnoreCases}
+	literals := make([]string, len(params.Patterns))
+	ignoreCases := make([]bool, len(params.Patterns))
+	for i, p := range params.Patterns {
+		if m.isIgnoreCase(i) {
+			p = "(?i:" + p + ")"
+		}
+		literal, fold, ok := pureLiteral(p)
+		if !ok {
+			return nil
+		}
+		literals[i], ignoreCases[i] = literal, fold
+	}
+	return &MatcherParams{
+		Patterns:     literals,
+		IgnoreCases:  ignoreCases,
+		FixedStrings: true,
+		WordRegexp:   params.WordRegexp,
+		LineRegexp:   params.LineRegexp,
+	}
+}
+
+func isFoldLiteral(re *syntax.Regexp) bool {
+	return re.Flags&syntax.FoldCase != 0
+}
@@ -0,0 +1,81 @@
+package book
+
+import (
+	"regexp/syntax"
+	"testing"
+)
+
+func TestRequiredLiteral(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+		fold     bool
+	}{
+		{`foo`, "foo", false},
+		{`ERROR.*timeout`, "timeout", false},
+		{`^\[(warn|error)\] disk full$`, "] disk full", false},
+		{`(?i:Fo)bar`, "bar", false},
+		{`(?i)foo\d+`, "FOO", true},
+		{`(abcd)+x`, "abcd", false},
+		{`(abcd){2,3}x`, "abcd", false},
+		{`(abcd)*x`, "x", false},
+		{`foo|bar`, "", false},
+		{`a?`, "", false},
+	}
+	for _, test := range tests {
+		re, err := syntax.Parse(test.pattern, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		literal, fold := requiredLiteral(re)
+		if literal != test.expected || fold != test.fold {
+			t.Error("requiredLiteral returned an unexpected literal.", test.pattern, literal, fold)
+		}
+	}
+}
+
+func TestLiteralPrefilter(t *testing.T) {
+	if newLiteralPrefilter(`a|b`) != nil || newLiteralPrefilter(`(`) != nil {
+		t.Error("Prefilter shouldn't be created without a required literal.")
+	}
+	f := newLiteralPrefilter(`(?i)kelvin\d`)
+	if !f.contains([]byte("KELVIN1")) || f.contains([]byte("kelvi")) {
+		t.Error("Prefilter should search a literal ignoring case.")
+	}
+}
+
+func TestRegexpMatcherPrefilter(t *testing.T) {
+	m := newRegexpMatcherWithParams(&MatcherParams{Patterns: []string{`ERROR.*timeout`, `x+y`}})
+	if m.prefilters[0] == nil || m.prefilters[1] == nil {
+		t.Error("Prefilters should be created.")
+	}
+	if !m.Match([]byte("ERROR: read timeout")) || m.Match([]byte("ERROR: timeou")) || m.Match([]byte("timeout ERROR")) {
+		t.Error("Match should work with prefilters.")
+	}
+	spans := m.MatchSpans([]byte("xxy ERROR timeout"))
+	if len(spans) != 2 || spans[0] != (MatchSpan{0, 3, 1}) || spans[1] != (MatchSpan{4, 17, 0}) {
+		t.Error("MatchSpans should work with prefilters.", spans)
+	}
+}
+
+func TestNewMatcherSelectsBytesMatcherForLiterals(t *testing.T) {
+	m := NewMatcher(&MatcherParams{Patterns: []string{`foo`, `a\.b`}})
+	bm, ok := m.(*bytesMatcher)
+	if !ok || string(bm.bytesPatterns[1]) != "a.b" {
+		t.Error("Literal patterns should use bytesMatcher.", m)
+	}
+	if !m.Match([]byte("xa.b")) || m.Match([]byte("axb")) {
+		t.Error("Escaped literal should match literally.")
+	}
+
+	m = NewMatcher(&MatcherParams{Patterns: []string{`Foo`, `bar`}, IgnoreCases: []bool{true, false}})
+	if _, ok := m.(*bytesMatcher); !ok || !m.Match([]byte("FOO")) || m.Match([]byte("BAR")) {
+		t.Error("Literal patterns should keep case sensitivity of each pattern.")
+	}
+	if _, ok := NewMatcher(&MatcherParams{Patterns: []string{`foo`, `ba+r`}}).(*regexpMatcher); !ok {
+		t.Error("Patterns with operators should use regexpMatcher.")
+	}
+	if _, ok := NewMatcher(&MatcherParams{Patterns: []string{``}}).(*regexpMatcher); !ok {
+		t.Error("Empty pattern should use regexpMatcher.")
+	}
+}
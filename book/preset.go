@@ -0,0 +1,183 @@
+package book
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// PresetPrefix refers to a preset in a patterns file. e.g. @preset:email
+	PresetPrefix = "@preset:"
+)
+
+const (
+	hexGroup = `[0-9A-Fa-f]{1,4}`
+	ipv4Byte = `(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])`
+
+	ipv4Pattern = `\b(?:` + ipv4Byte + `\.){3}` + ipv4Byte + `\b`
+	// An address has a group at least, so "::" alone doesn't match.
+	ipv6Pattern = `(?:` + hexGroup + `:){7}` + hexGroup +
+		`|(?:(?:` + hexGroup + `:){0,6}` + hexGroup + `)?::(?:` + hexGroup + `:){0,6}` + hexGroup +
+		`|(?:` + hexGroup + `:){0,6}` + hexGroup + `::`
+)
+
+// Built-in presets. They are Go regexps.
+var builtinPresets = map[string]string{
+	"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`,
+	"ipv4":  ipv4Pattern,
+	"ipv6":  ipv6Pattern,
+	"jwt":   `\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+	"mac":   `\b[0-9A-Fa-f]{2}(?:[:-][0-9A-Fa-f]{2}){5}\b`,
+	"url":   `\b(?:https?|ftp)://[A-Za-z0-9.-]+(?::[0-9]+)?(?:/[^\s"'<>]*)?`,
+	"uuid":  `\b[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}\b`,
+}
+
+// presetCheck confirms a match of a built-in preset at textBytes[start:end]
+// by the characters around it. Go regexps don't have lookaround.
+type presetCheck func(textBytes []byte, start, end int) bool
+
+// presetChecks are checks of built-in presets by their patterns. Presets
+// loaded from files are not checked.
+var presetChecks = map[string]presetCheck{
+	ipv4Pattern: isIPv4Boundary,
+	ipv6Pattern: isIPv6Boundary,
+}
+
+// presetChecksOf returns checks of patterns, or nil when no pattern is a
+// built-in preset which has a check.
+func presetChecksOf(patterns []string) []presetCheck {
+	var checks []presetCheck
+	for i, pattern := range patterns {
+		if check, ok := presetChecks[pattern]; ok {
+			if checks == nil {
+				checks = make([]presetCheck, len(patterns))
+			}
+			checks[i] = check
+		}
+	}
+	return checks
+}
+
+// acceptsPresetMatch checks a match of the pattern at patternIndex with
+// checks by presetChecksOf.
+func acceptsPresetMatch(checks []presetCheck, patternIndex int, textBytes []byte, start, end int) bool {
+	return checks == nil || checks[patternIndex] == nil || checks[patternIndex](textBytes, start, end)
+}
+
+// isIPv4Boundary rejects an address which is a part of longer numbers
+// joined by dots. e.g. 1.2.3.4.5
+func isIPv4Boundary(textBytes []byte, start, end int) bool {
+	if start > 0 {
+		c := textBytes[start-1]
+		if isDigitByte(c) || (c == '.' && start > 1 && isDigitByte(textBytes[start-2])) {
+			return false
+		}
+	}
+	if end < len(textBytes) {
+		c := textBytes[end]
+		if isDigitByte(c) || (c == '.' && end+1 < len(textBytes) && isDigitByte(textBytes[end+1])) {
+			return false
+		}
+	}
+	return true
+}
+
+// isIPv6Boundary rejects an address next to a word character or a colon.
+// e.g. std::vector
+func isIPv6Boundary(textBytes []byte, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRune(textBytes[:start])
+		if isWordRune(r) || r == ':' {
+			return false
+		}
+	}
+	if end < len(textBytes) {
+		r, _ := utf8.DecodeRune(textBytes[end:])
+		if isWordRune(r) || r == ':' || (r == '.' && end+1 < len(textBytes) && isDigitByte(textBytes[end+1])) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigitByte(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+// Presets are named regexp patterns.
+type Presets struct {
+	patterns map[string]string
+}
+
+// NewPresets creates Presets which have built-in presets.
+func NewPresets() *Presets {
+	p := &Presets{patterns: make(map[string]string, len(builtinPresets))}
+	for name, pattern := range builtinPresets {
+		p.patterns[name] = pattern
+	}
+	return p
+}
+
+// LoadDir adds presets in dir. Each file is a preset named by the file name
+// without its extension. The first line which isn't empty or a comment
+// starting with '#' is the pattern. They override built-in presets.
+func (p *Presets) LoadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		pattern, ok := presetPattern(string(data))
+		if !ok {
+			return fmt.Errorf("no pattern in %s", path)
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid pattern in %s: %v", path, err)
+		}
+		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		p.patterns[name] = pattern
+	}
+	return nil
+}
+
+// Pattern returns the pattern of a preset.
+func (p *Presets) Pattern(name string) (string, error) {
+	pattern, ok := p.patterns[name]
+	if !ok {
+		return "", fmt.Errorf("unknown preset %q", name)
+	}
+	return pattern, nil
+}
+
+// Names returns names of presets in sorted order.
+func (p *Presets) Names() []string {
+	names := make([]string, 0, len(p.patterns))
+	for name := range p.patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func presetPattern(text string) (string, bool) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(strings.TrimSpace(line)) > 0 && !strings.HasPrefix(line, "#") {
+			return line, true
+		}
+	}
+	return "", false
+}
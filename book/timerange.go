@@ -0,0 +1,137 @@
+package book
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	apacheLayout = "02/Jan/2006:15:04:05 -0700"
+	syslogLayout = "Jan _2 15:04:05"
+)
+
+var (
+	apacheTimeRegexp = regexp.MustCompile(`[0-9]{2}/[A-Z][a-z]{2}/[0-9]{4}:[0-9]{2}:[0-9]{2}:[0-9]{2} [+-][0-9]{4}`)
+	syslogTimeRegexp = regexp.MustCompile(`(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) [ 0-9][0-9] [0-9]{2}:[0-9]{2}:[0-9]{2}`)
+)
+
+// TimeRangeParams selects lines which have a timestamp in a range. A
+// timestamp is the first one in a line in Layout, RFC3339 like
+// "2006-01-02T15:04:05Z", Apache like "02/Jan/2006:15:04:05 -0700" or
+// syslog like "Jan  2 15:04:05". Lines without a timestamp aren't selected.
+// Patterns are matched with the whole line if they are given.
+type TimeRangeParams struct {
+	Since time.Time
+	Until time.Time
+	// Layout is a Go time layout. It is matched with text of the same
+	// length at the start of words.
+	Layout string
+	// Sorted stops reading input at a timestamp after Until.
+	Sorted bool
+}
+
+// timeRangeMatcher selects lines by TimeRangeParams and matcher if
+// patterns are given.
+type timeRangeMatcher struct {
+	matcher     Matcher
+	hasPatterns bool
+	params      TimeRangeParams
+	// Syslog timestamps don't have a year. The year of the range is used.
+	syslogYear int
+	passed     func()
+}
+
+// ParseTimeRange creates TimeRangeParams from dates or timestamps like
+// "2024-01-31" or "2024-01-31T10:00:00+09:00". An empty text has no bound.
+// until with a date only covers the whole day. Times without a time zone
+// are in UTC.
+func ParseTimeRange(since, until string) (*TimeRangeParams, error) {
+	r, err := parseDateRange(since + ".." + until)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time range %q..%q", since, until)
+	}
+	return &TimeRangeParams{Since: r.since, Until: r.until}, nil
+}
+
+// newTimeRangeMatcher creates a matcher. passed is called when a line
+// after the range is found in sorted input.
+func newTimeRangeMatcher(m Matcher, params *TimeRangeParams, hasPatterns bool, passed func()) (tm *timeRangeMatcher) {
+	tm = new(timeRangeMatcher)
+	tm.matcher = m
+	tm.hasPatterns = hasPatterns
+	tm.params = *params
+	tm.passed = passed
+	tm.syslogYear = time.Now().Year()
+	if !params.Since.IsZero() {
+		tm.syslogYear = params.Since.Year()
+	} else if !params.Until.IsZero() {
+		tm.syslogYear = params.Until.Year()
+	}
+	return
+}
+
+func (m *timeRangeMatcher) Match(textBytes []byte) bool {
+	t, ok := m.timestamp(textBytes)
+	if !ok {
+		return false
+	}
+	if !m.params.Until.IsZero() && t.After(m.params.Until) {
+		if m.params.Sorted && m.passed != nil {
+			m.passed()
+		}
+		return false
+	}
+	if !m.params.Since.IsZero() && t.Before(m.params.Since) {
+		return false
+	}
+	return !m.hasPatterns || m.matcher.Match(textBytes)
+}
+
+// MatchSpans returns spans of patterns. A timestamp isn't a span.
+func (m *timeRangeMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	if !m.hasPatterns {
+		return nil
+	}
+	return m.matcher.MatchSpans(textBytes)
+}
+
+// timestamp returns the first timestamp in textBytes.
+func (m *timeRangeMatcher) timestamp(textBytes []byte) (time.Time, bool) {
+	if m.params.Layout != "" {
+		if t, ok := findTimeWithLayout(textBytes, m.params.Layout); ok {
+			return t, true
+		}
+	}
+	for _, loc := range dateTokenRegexp.FindAllSubmatchIndex(textBytes, -1) {
+		if t, _, ok := dateFromSubmatch(textBytes, loc); ok {
+			return t, true
+		}
+	}
+	if loc := apacheTimeRegexp.FindIndex(textBytes); loc != nil {
+		if t, err := time.Parse(apacheLayout, string(textBytes[loc[0]:loc[1]])); err == nil {
+			return t, true
+		}
+	}
+	if loc := syslogTimeRegexp.FindIndex(textBytes); loc != nil {
+		if t, err := time.Parse(syslogLayout, string(textBytes[loc[0]:loc[1]])); err == nil {
+			return t.AddDate(m.syslogYear, 0, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// findTimeWithLayout parses text of the same length as layout at the start
+// of each word.
+func findTimeWithLayout(textBytes []byte, layout string) (time.Time, bool) {
+	for i := 0; i+len(layout) <= len(textBytes); i++ {
+		if i > 0 && isASCIIWord(rune(textBytes[i-1])) {
+			continue
+		}
+		t, err := time.Parse(layout, string(textBytes[i:i+len(layout)]))
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
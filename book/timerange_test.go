@@ -0,0 +1,109 @@
+package book
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	params, err := ParseTimeRange("2024-01-31T10:00:00+09:00", "2024-02-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !params.Since.Equal(time.Date(2024, 1, 31, 1, 0, 0, 0, time.UTC)) ||
+		!params.Until.Equal(time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond)) {
+		t.Error("ParseTimeRange returned an unexpected range.", params.Since, params.Until)
+	}
+	if params, err := ParseTimeRange("", "2024-02-01"); err != nil || !params.Since.IsZero() {
+		t.Error("Since should be optional.", err)
+	}
+	for _, r := range [][]string{{"yesterday", ""}, {"2024-02-01", "2024-01-01"}} {
+		if _, err := ParseTimeRange(r[0], r[1]); err == nil {
+			t.Error("Invalid range should be an error.", r)
+		}
+	}
+}
+
+func TestTimeRangeMatcher(t *testing.T) {
+	params, _ := ParseTimeRange("2024-01-31T10:00:00Z", "2024-01-31T11:00:00Z")
+	m := newTimeRangeMatcher(NewMatcher(&MatcherParams{}), params, false, nil)
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		{"2024-01-31T10:30:00Z INFO start", true},
+		{"2024-01-31 10:30:00,123 INFO start", true},
+		{"2024-01-31T11:30:00+01:00 INFO start", true},
+		{"2024-01-31T09:59:59Z INFO start", false},
+		{`127.0.0.1 - - [31/Jan/2024:10:15:00 +0000] "GET / HTTP/1.1" 200`, true},
+		{`127.0.0.1 - - [31/Jan/2024:12:15:00 +0000] "GET / HTTP/1.1" 200`, false},
+		{"Jan 31 10:59:59 host sshd[1]: accepted", true},
+		{"Jan 31 11:00:01 host sshd[1]: accepted", false},
+		{"no timestamp", false},
+	}
+	for _, test := range tests {
+		if m.Match([]byte(test.text)) != test.expected {
+			t.Error("timeRangeMatcher returned an unexpected result.", test.text)
+		}
+	}
+
+	params.Layout = "2006.01.02 15h04"
+	m = newTimeRangeMatcher(NewMatcher(&MatcherParams{Patterns: []string{"ERROR"}}), params, true, nil)
+	if !m.Match([]byte("[2024.01.31 10h30] ERROR x")) || m.Match([]byte("[2024.01.31 10h30] INFO x")) ||
+		m.Match([]byte("[2024.01.31 12h30] ERROR x")) {
+		t.Error("timeRangeMatcher should use a layout and patterns.")
+	}
+	if spans := m.MatchSpans([]byte("2024.01.31 10h30 ERROR")); len(spans) != 1 || spans[0].Start != 17 {
+		t.Error("MatchSpans should return spans of patterns.", spans)
+	}
+}
+
+func TestFindTimeRangeSorted(t *testing.T) {
+	var text string
+	for i := 0; i < 60; i++ {
+		text += strings.Repeat(time.Date(2024, 1, 31, 10, i, 0, 0, time.UTC).Format(time.RFC3339)+" line\n", 100)
+	}
+	c := newChapterBytes([]byte(text))
+	defer c.Close()
+	var input Input
+	c.newInputFunc = func() (Input, error) {
+		input, _ = NewBytesInput(c.bytes)
+		return input, nil
+	}
+	params, _ := ParseTimeRange("2024-01-31T10:10:00Z", "2024-01-31T10:19:59Z")
+	params.Sorted = true
+	if count := c.Find(&FindParams{TimeRange: params}); count != 1000 {
+		t.Error("Find should select lines in the range.", count)
+	}
+	if input.(*bytesInput).stopped == 0 {
+		t.Error("Input should be stopped after the range.")
+	}
+
+	if count := c.Find(&FindParams{TimeRange: params, InvertMatch: true}); count != 5000 || input.(*bytesInput).stopped != 0 {
+		t.Error("Find should read all lines when inverted.", count)
+	}
+
+	params.Sorted = false
+	if count := c.Find(&FindParams{TimeRange: params}); count != 1000 || input.(*bytesInput).stopped != 0 {
+		t.Error("Find should read all lines without Sorted.", count)
+	}
+}
+
+func TestFindTimeRangeWithFields(t *testing.T) {
+	c := newChapterBytes([]byte("time,status\n2024-01-31T10:00:00Z,500\n2024-01-31T12:00:00Z,500\n"))
+	defer c.Close()
+	params, _ := ParseTimeRange("2024-01-31T09:00:00Z", "2024-01-31T11:00:00Z")
+	var fields []string
+	count := c.Find(&FindParams{
+		Patterns:  []string{"500"},
+		Fields:    &FieldParams{Delimiter: ',', Columns: []string{"status"}},
+		TimeRange: params,
+		Handler: func(params *FoundParams) {
+			fields = append(fields, params.MatchedFields()...)
+		},
+	})
+	if count != 1 || len(fields) != 1 || fields[0] != "status" {
+		t.Error("Find should select lines by fields in the range.", count, fields)
+	}
+}
@@ -0,0 +1,403 @@
+package book
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// TypedMatcher finds tokens of a type (e.g. numbers) in a line and selects
+// tokens in a range. It can be shared by goroutines.
+type TypedMatcher interface {
+	Matcher
+	// String returns the type and the range. e.g. "num:500..599"
+	String() string
+}
+
+type typedFinder interface {
+	// find returns at most n spans of selected tokens. If n < 0, it
+	// returns all spans.
+	find(textBytes []byte, n int) []MatchSpan
+}
+
+type typedMatcher struct {
+	name   string
+	spec   string
+	finder typedFinder
+}
+
+type numberRange struct {
+	min    float64
+	max    float64
+	hasMin bool
+	hasMax bool
+}
+
+type numberRangeFinder struct {
+	ranges []numberRange
+}
+
+type cidrFinder struct {
+	networks []*net.IPNet
+}
+
+type dateRange struct {
+	since time.Time
+	until time.Time
+}
+
+type dateRangeFinder struct {
+	ranges []dateRange
+}
+
+// typedPatternMatcher matches patterns or typed matchers. PatternIndex of
+// spans found by typedMatchers[i] is patternCount+i.
+type typedPatternMatcher struct {
+	matcher       Matcher
+	typedMatchers []TypedMatcher
+	patternCount  int
+}
+
+var (
+	numberTokenRegexp = regexp.MustCompile(`-?[0-9]+(?:\.[0-9]+)?`)
+	ipTokenRegexp     = regexp.MustCompile(`(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f.]*|[0-9]{1,3}(?:\.[0-9]{1,3}){3}`)
+	dateTokenRegexp   = regexp.MustCompile(`([0-9]{4})([-/])([0-9]{2})([-/])([0-9]{2})` +
+		`(?:[T ]([0-9]{2}):([0-9]{2})(?::([0-9]{2})(?:[.,]([0-9]+))?)?(Z|[+-][0-9]{2}:?[0-9]{2})?)?`)
+)
+
+// NewNumberRangeMatcher creates a TypedMatcher for numbers. spec is a comma
+// separated list of ranges like "500..599", "1.5..", "..-1" or "404". Both
+// ends of a range are inclusive.
+func NewNumberRangeMatcher(spec string) (TypedMatcher, error) {
+	finder := new(numberRangeFinder)
+	for _, item := range strings.Split(spec, ",") {
+		r, err := parseNumberRange(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		finder.ranges = append(finder.ranges, r)
+	}
+	return &typedMatcher{name: "num", spec: spec, finder: finder}, nil
+}
+
+// NewCIDRMatcher creates a TypedMatcher for IPv4 and IPv6 addresses. spec is
+// a comma separated list of networks like "10.0.0.0/8" or addresses.
+func NewCIDRMatcher(spec string) (TypedMatcher, error) {
+	finder := new(cidrFinder)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			finder.networks = append(finder.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", item)
+		}
+		finder.networks = append(finder.networks, network)
+	}
+	return &typedMatcher{name: "cidr", spec: spec, finder: finder}, nil
+}
+
+// NewDateRangeMatcher creates a TypedMatcher for dates like 2024-01-31 and
+// timestamps like 2024-01-31T10:00:00Z. spec is a comma separated list of
+// ranges like "2024-01-01..2024-01-31", "2024-01-01T12:00..". A date in spec
+// covers the whole day. Times without a time zone are in UTC.
+func NewDateRangeMatcher(spec string) (TypedMatcher, error) {
+	finder := new(dateRangeFinder)
+	for _, item := range strings.Split(spec, ",") {
+		r, err := parseDateRange(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		finder.ranges = append(finder.ranges, r)
+	}
+	return &typedMatcher{name: "date", spec: spec, finder: finder}, nil
+}
+
+func (m *typedMatcher) Match(textBytes []byte) bool {
+	return len(m.finder.find(textBytes, 1)) > 0
+}
+
+func (m *typedMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	return m.finder.find(textBytes, -1)
+}
+
+func (m *typedMatcher) String() string {
+	return m.name + ":" + m.spec
+}
+
+func parseNumberRange(text string) (r numberRange, err error) {
+	low, high := text, text
+	if n := strings.Index(text, ".."); n >= 0 {
+		low, high = text[:n], text[n+2:]
+	}
+	if low == "" && high == "" {
+		return r, fmt.Errorf("invalid number range %q", text)
+	}
+	if low != "" {
+		if r.min, err = strconv.ParseFloat(low, 64); err != nil {
+			return r, fmt.Errorf("invalid number range %q", text)
+		}
+		r.hasMin = true
+	}
+	if high != "" {
+		if r.max, err = strconv.ParseFloat(high, 64); err != nil {
+			return r, fmt.Errorf("invalid number range %q", text)
+		}
+		r.hasMax = true
+	}
+	if r.hasMin && r.hasMax && r.min > r.max {
+		return r, fmt.Errorf("invalid number range %q", text)
+	}
+	return r, nil
+}
+
+func (r numberRange) contains(value float64) bool {
+	return (!r.hasMin || r.min <= value) && (!r.hasMax || value <= r.max)
+}
+
+// find selects numbers which are not a part of words or other numbers.
+// e.g. 500 in "status=500" and "500ms", but not in "v500" or "1.500.2".
+func (f *numberRangeFinder) find(textBytes []byte, n int) []MatchSpan {
+	var spans []MatchSpan
+	for _, loc := range numberTokenRegexp.FindAllIndex(textBytes, -1) {
+		start, end := loc[0], loc[1]
+		if textBytes[start] == '-' && start > 0 && isTokenRune(textBytes, start, true) {
+			start++
+		}
+		if isNumberTokenBoundary(textBytes, start, end) {
+			continue
+		}
+		value, err := strconv.ParseFloat(string(textBytes[start:end]), 64)
+		if err != nil {
+			continue
+		}
+		for _, r := range f.ranges {
+			if r.contains(value) {
+				spans = append(spans, MatchSpan{Start: start, End: end})
+				break
+			}
+		}
+		if n >= 0 && len(spans) >= n {
+			break
+		}
+	}
+	return spans
+}
+
+// isNumberTokenBoundary checks the number at start:end is adjacent to a
+// word or to another number.
+func isNumberTokenBoundary(textBytes []byte, start, end int) bool {
+	if start > 0 {
+		if r, _ := utf8.DecodeLastRune(textBytes[:start]); isWordRune(r) || r == '.' {
+			return true
+		}
+	}
+	if end < len(textBytes) && textBytes[end] == '.' && end+1 < len(textBytes) && isASCIIDigit(rune(textBytes[end+1])) {
+		return true
+	}
+	return false
+}
+
+// isTokenRune checks the rune before pos (or at pos) is a word rune.
+func isTokenRune(textBytes []byte, pos int, before bool) bool {
+	var r rune
+	if before {
+		r, _ = utf8.DecodeLastRune(textBytes[:pos])
+	} else {
+		r, _ = utf8.DecodeRune(textBytes[pos:])
+	}
+	return isWordRune(r)
+}
+
+// find selects IPv4 and IPv6 addresses which are not a part of words or
+// other numbers. e.g. 10.0.0.1 in "10.0.0.1:8080" and "[::1]:80".
+func (f *cidrFinder) find(textBytes []byte, n int) []MatchSpan {
+	var spans []MatchSpan
+	for _, loc := range ipTokenRegexp.FindAllIndex(textBytes, -1) {
+		start, end := loc[0], loc[1]
+		for end > start && textBytes[end-1] == '.' {
+			end--
+		}
+		if isNumberTokenBoundary(textBytes, start, end) || (end < len(textBytes) && isTokenRune(textBytes, end, false)) {
+			continue
+		}
+		ip := net.ParseIP(string(textBytes[start:end]))
+		if ip == nil {
+			continue
+		}
+		for _, network := range f.networks {
+			if network.Contains(ip) {
+				spans = append(spans, MatchSpan{Start: start, End: end})
+				break
+			}
+		}
+		if n >= 0 && len(spans) >= n {
+			break
+		}
+	}
+	return spans
+}
+
+func parseDateRange(text string) (r dateRange, err error) {
+	low, high := text, text
+	if n := strings.Index(text, ".."); n >= 0 {
+		low, high = text[:n], text[n+2:]
+	}
+	if low == "" && high == "" {
+		return r, fmt.Errorf("invalid date range %q", text)
+	}
+	if low != "" {
+		since, _, ok := parseDateToken(low)
+		if !ok {
+			return r, fmt.Errorf("invalid date %q", low)
+		}
+		r.since = since
+	}
+	if high != "" {
+		until, hasTime, ok := parseDateToken(high)
+		if !ok {
+			return r, fmt.Errorf("invalid date %q", high)
+		}
+		if !hasTime {
+			until = until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		r.until = until
+	}
+	if !r.since.IsZero() && !r.until.IsZero() && r.since.After(r.until) {
+		return r, fmt.Errorf("invalid date range %q", text)
+	}
+	return r, nil
+}
+
+func (r dateRange) contains(t time.Time) bool {
+	return (r.since.IsZero() || !t.Before(r.since)) && (r.until.IsZero() || !t.After(r.until))
+}
+
+// parseDateToken parses a whole text as a date or a timestamp.
+func parseDateToken(text string) (time.Time, bool, bool) {
+	loc := dateTokenRegexp.FindStringSubmatchIndex(text)
+	if loc == nil || loc[0] != 0 || loc[1] != len(text) {
+		return time.Time{}, false, false
+	}
+	return dateFromSubmatch([]byte(text), loc)
+}
+
+// dateFromSubmatch creates a time from a match of dateTokenRegexp. It
+// returns whether the match has a time of day, and false if the date is
+// invalid.
+func dateFromSubmatch(textBytes []byte, loc []int) (time.Time, bool, bool) {
+	group := func(i int) string {
+		if loc[2*i] < 0 {
+			return ""
+		}
+		return string(textBytes[loc[2*i]:loc[2*i+1]])
+	}
+	number := func(i int) int {
+		value, _ := strconv.Atoi(group(i))
+		return value
+	}
+	if group(2) != group(4) {
+		return time.Time{}, false, false
+	}
+
+	year, month, day := number(1), number(3), number(5)
+	hour, minute, second := number(6), number(7), number(8)
+	nanosecond := 0
+	if fraction := group(9); fraction != "" {
+		fraction = (fraction + "000000000")[:9]
+		nanosecond, _ = strconv.Atoi(fraction)
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 60 {
+		return time.Time{}, false, false
+	}
+
+	location := time.UTC
+	if zone := group(10); zone != "" && zone != "Z" {
+		zone = strings.Replace(zone, ":", "", 1)
+		offset := 60 * (60*atoi(zone[1:3]) + atoi(zone[3:5]))
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		location = time.FixedZone(zone, offset)
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, nanosecond, location)
+	if t.Day() != day {
+		return time.Time{}, false, false
+	}
+	return t, group(6) != "", true
+}
+
+func atoi(text string) int {
+	value, _ := strconv.Atoi(text)
+	return value
+}
+
+func (f *dateRangeFinder) find(textBytes []byte, n int) []MatchSpan {
+	var spans []MatchSpan
+	for _, loc := range dateTokenRegexp.FindAllSubmatchIndex(textBytes, -1) {
+		start, end := loc[0], loc[1]
+		if (start > 0 && isTokenRune(textBytes, start, true)) || (end < len(textBytes) && isASCIIDigit(rune(textBytes[end]))) {
+			continue
+		}
+		t, _, ok := dateFromSubmatch(textBytes, loc)
+		if !ok {
+			continue
+		}
+		for _, r := range f.ranges {
+			if r.contains(t) {
+				spans = append(spans, MatchSpan{Start: start, End: end})
+				break
+			}
+		}
+		if n >= 0 && len(spans) >= n {
+			break
+		}
+	}
+	return spans
+}
+
+func newTypedPatternMatcher(m Matcher, typedMatchers []TypedMatcher, patternCount int) *typedPatternMatcher {
+	return &typedPatternMatcher{matcher: m, typedMatchers: typedMatchers, patternCount: patternCount}
+}
+
+func (m *typedPatternMatcher) Match(textBytes []byte) bool {
+	if m.patternCount > 0 && m.matcher.Match(textBytes) {
+		return true
+	}
+	for _, typedMatcher := range m.typedMatchers {
+		if typedMatcher.Match(textBytes) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *typedPatternMatcher) MatchSpans(textBytes []byte) []MatchSpan {
+	var spans []MatchSpan
+	if m.patternCount > 0 {
+		spans = m.matcher.MatchSpans(textBytes)
+	}
+	for i, typedMatcher := range m.typedMatchers {
+		for _, span := range typedMatcher.MatchSpans(textBytes) {
+			span.PatternIndex = m.patternCount + i
+			spans = append(spans, span)
+		}
+	}
+	sort.Stable(matchSpans(spans))
+	return spans
+}
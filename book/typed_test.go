@@ -0,0 +1,149 @@
+package book
+
+import (
+	"testing"
+)
+
+func TestNumberRangeMatcher(t *testing.T) {
+	m, err := NewNumberRangeMatcher("500..599,404")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		{"status=503 size=12", true},
+		{"took 500ms", true},
+		{"GET /x 404", true},
+		{"status=200", false},
+		{"v500", false},
+		{"1.500.2", false},
+		{"500.5", true},
+		{"600", false},
+	}
+	for _, test := range tests {
+		if m.Match([]byte(test.text)) != test.expected {
+			t.Error("NumberRangeMatcher returned an unexpected result.", test.text)
+		}
+	}
+
+	spans := m.MatchSpans([]byte("a=404 b=550"))
+	if len(spans) != 2 || spans[0] != (MatchSpan{2, 5, 0}) || spans[1] != (MatchSpan{8, 11, 0}) {
+		t.Error("MatchSpans should return all numbers in ranges.", spans)
+	}
+
+	m, _ = NewNumberRangeMatcher("..-1")
+	if !m.Match([]byte("delta -3")) || m.Match([]byte("delta 3")) {
+		t.Error("NumberRangeMatcher should handle negative numbers.")
+	}
+
+	for _, spec := range []string{"", "a..b", "5..1", "1..2..3"} {
+		if _, err := NewNumberRangeMatcher(spec); err == nil {
+			t.Error("Invalid number range should be an error.", spec)
+		}
+	}
+}
+
+func TestCIDRMatcher(t *testing.T) {
+	m, err := NewCIDRMatcher("10.0.0.0/8, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		{"from 10.0.0.1:8080", true},
+		{"client [::1]:443", true},
+		{"from 10.1.2.3.", true},
+		{"from 11.0.0.1", false},
+		{"from 110.0.0.1", false},
+		{"version 10.0.0.1.5", false},
+		{"::2", false},
+	}
+	for _, test := range tests {
+		if m.Match([]byte(test.text)) != test.expected {
+			t.Error("CIDRMatcher returned an unexpected result.", test.text)
+		}
+	}
+
+	for _, spec := range []string{"10.0.0.0/33", "host"} {
+		if _, err := NewCIDRMatcher(spec); err == nil {
+			t.Error("Invalid CIDR should be an error.", spec)
+		}
+	}
+}
+
+func TestDateRangeMatcher(t *testing.T) {
+	m, err := NewDateRangeMatcher("2024-01-01..2024-01-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text     string
+		expected bool
+	}{
+		{"2024-01-15 started", true},
+		{"at 2024-01-31T23:59:59Z", true},
+		{"at 2024/01/31 10:00", true},
+		{"at 2024-02-01T00:00:00Z", false},
+		{"at 2024-01-31T23:30:00-01:00", false},
+		{"2023-12-31", false},
+		{"2024-01/15", false},
+	}
+	for _, test := range tests {
+		if m.Match([]byte(test.text)) != test.expected {
+			t.Error("DateRangeMatcher returned an unexpected result.", test.text)
+		}
+	}
+
+	m, _ = NewDateRangeMatcher("2024-01-01T12:00..")
+	if !m.Match([]byte("2024-01-01T12:00:00Z")) || m.Match([]byte("2024-01-01T11:59:59Z")) {
+		t.Error("DateRangeMatcher should compare times.")
+	}
+
+	for _, spec := range []string{"", "yesterday..", "2024-02-01..2024-01-01"} {
+		if _, err := NewDateRangeMatcher(spec); err == nil {
+			t.Error("Invalid date range should be an error.", spec)
+		}
+	}
+}
+
+func TestTypedPatternMatcher(t *testing.T) {
+	num, _ := NewNumberRangeMatcher("500..599")
+	m := newTypedPatternMatcher(NewMatcher(&MatcherParams{Patterns: []string{"GET"}}), []TypedMatcher{num}, 1)
+	if !m.Match([]byte("GET / 200")) || !m.Match([]byte("POST / 500")) || m.Match([]byte("POST / 200")) {
+		t.Error("typedPatternMatcher should select lines by patterns or typed matchers.")
+	}
+	spans := m.MatchSpans([]byte("GET / 503"))
+	if len(spans) != 2 || spans[0] != (MatchSpan{0, 3, 0}) || spans[1] != (MatchSpan{6, 9, 1}) {
+		t.Error("Typed matchers should have pattern indexes after patterns.", spans)
+	}
+
+	m = newTypedPatternMatcher(NewMatcher(&MatcherParams{}), []TypedMatcher{num}, 0)
+	if !m.Match([]byte("code 503")) || m.Match([]byte("code 200")) {
+		t.Error("typedPatternMatcher should work without patterns.")
+	}
+}
+
+func TestFindTypedMatchers(t *testing.T) {
+	c := newChapterBytes([]byte("GET 200\nGET 503\nPOST 201\n"))
+	defer c.Close()
+	num, _ := NewNumberRangeMatcher("500..599")
+	var labels []string
+	count := c.Find(&FindParams{
+		TypedMatchers: []TypedMatcher{num},
+		Handler: func(params *FoundParams) {
+			labels = append(labels, params.MatchedPatternLabels()...)
+		},
+	})
+	if count != 1 || len(labels) != 1 || labels[0] != "num:500..599" {
+		t.Error("Typed matcher should select a line and its label should be its spec.", count, labels)
+	}
+
+	count = c.Find(&FindParams{Patterns: []string{"POST"}, TypedMatchers: []TypedMatcher{num}})
+	if count != 2 {
+		t.Error("Find should select lines by patterns or typed matchers.", count)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/codegangsta/cli"
 	"github.com/hata/gorep/book"
@@ -29,13 +30,19 @@ OPTIONS:
 	`
 )
 
-func main() {
-	cpus := runtime.NumCPU()
-	runtime.GOMAXPROCS(cpus)
+// errQuietFound stops walking files when -q finds a line.
+var errQuietFound = errors.New("found a line in quiet mode")
 
+// newApp creates cli.App which sets appOptions by flags.
+func newApp() (app *cli.App) {
 	cli.AppHelpTemplate = appHelpTemplate
+	// -v is --invert-match like grep.
+	cli.VersionFlag = cli.BoolFlag{
+		Name:  "version",
+		Usage: "print the version",
+	}
 
-	app := cli.NewApp()
+	app = cli.NewApp()
 	app.Name = AppName
 	app.Version = Version
 	app.Usage = AppUsage
@@ -43,18 +50,80 @@ func main() {
 	app.Email = ""
 	app.Flags = Flags
 	app.Action = flagAction
+	return
+}
+
+func main() {
+	cpus := runtime.NumCPU()
+	runtime.GOMAXPROCS(cpus)
 
-	app.Run(os.Args)
+	newApp().Run(os.Args)
 
 	var handler book.FoundHandler
 	totalCount := book.FoundCountType(0)
 
-	if len(appOptions.patterns) == 0 {
+	if len(appOptions.patterns) == 0 && len(appOptions.typedMatchers) == 0 && appOptions.logfmtParams == nil &&
+		appOptions.timeRangeParams == nil && appOptions.hexPatterns == nil {
 		os.Exit(1)
 		return
 	}
 
-	if !appOptions.fixedStrings {
+	if appOptions.multiline && (appOptions.invertMatch || appOptions.onlyMatching || appOptions.maxErrors > 0 ||
+		appOptions.allMatch || appOptions.expression != nil || appOptions.typedMatchers != nil ||
+		appOptions.fieldParams != nil || appOptions.jsonParams != nil || appOptions.logfmtParams != nil ||
+		appOptions.timeRangeParams != nil || appOptions.patternLabel) {
+		fmt.Println("-U cannot be used with -v, -o, -k, --all-match, --expr, --num, --cidr, --date, --json, --field, " +
+			"--since, --until, --pattern-label and delimited field options.")
+		return
+	}
+
+	if (appOptions.fieldParams != nil && appOptions.jsonParams != nil) ||
+		(appOptions.logfmtParams != nil && (appOptions.fieldParams != nil || appOptions.jsonParams != nil)) {
+		fmt.Println("--json, --field and delimited field options cannot be used together.")
+		return
+	}
+
+	if appOptions.hexPatterns != nil && (appOptions.invertMatch || appOptions.multiline || appOptions.allMatch ||
+		appOptions.expression != nil) {
+		fmt.Println("--hex cannot be used with -v, -U, --all-match and --expr.")
+		return
+	}
+
+	if appOptions.usesPresets && (appOptions.fixedStrings || appOptions.basicRegexp || appOptions.extendedRegexp ||
+		appOptions.maxErrors > 0 || appOptions.expression != nil) {
+		fmt.Println("Presets cannot be used with -F, -G, -E, -k and --expr.")
+		return
+	}
+
+	if appOptions.maxErrors > 0 && (appOptions.wordRegexp || appOptions.lineRegexp || appOptions.extendedRegexp ||
+		appOptions.basicRegexp || appOptions.perlRegexp) {
+		fmt.Println("-k cannot be used with -w, -x, -E, -G and -P.")
+		return
+	}
+
+	if appOptions.perlRegexp && (appOptions.fixedStrings || appOptions.basicRegexp || appOptions.extendedRegexp ||
+		appOptions.maxErrors > 0 || appOptions.multiline) {
+		fmt.Println("-P cannot be used with -F, -G, -E, -k and -U.")
+		return
+	}
+
+	if !appOptions.fixedStrings && appOptions.maxErrors == 0 && (appOptions.basicRegexp || appOptions.extendedRegexp) {
+		err := translatePosixPatterns(&appOptions)
+		if err != nil {
+			fmt.Println("Failed to translate POSIX regexp.", err)
+			return
+		}
+	}
+
+	if appOptions.perlRegexp {
+		for _, p := range appOptions.patterns {
+			err := book.ValidatePerlRegexp(p)
+			if err != nil {
+				fmt.Println("Failed to compile regexp.", err)
+				return
+			}
+		}
+	} else if !appOptions.fixedStrings && appOptions.maxErrors == 0 {
 		for _, p := range appOptions.patterns {
 			_, err := regexp.Compile(p)
 			if err != nil {
@@ -66,6 +135,18 @@ func main() {
 
 	if appOptions.count || appOptions.quiet || appOptions.filesWithMatches || appOptions.filesWithoutMatch {
 		handler = nil
+	} else if appOptions.hexPatterns != nil && appOptions.showFilenameFlag {
+		handler = book.FileNameHexFoundHandler
+	} else if appOptions.hexPatterns != nil {
+		handler = book.HexFoundHandler
+	} else if appOptions.onlyMatching && appOptions.lineNumber && appOptions.showFilenameFlag {
+		handler = book.FileNameLineNumberOnlyMatchingFoundHandler
+	} else if appOptions.onlyMatching && appOptions.lineNumber {
+		handler = book.LineNumberOnlyMatchingFoundHandler
+	} else if appOptions.onlyMatching && appOptions.showFilenameFlag {
+		handler = book.FileNameOnlyMatchingFoundHandler
+	} else if appOptions.onlyMatching {
+		handler = book.OnlyMatchingFoundHandler
 	} else if appOptions.lineNumber && appOptions.showFilenameFlag {
 		handler = book.FileNameLineNumberFoundHandler
 	} else if appOptions.lineNumber {
@@ -78,6 +159,9 @@ func main() {
 
 	for _, aFile := range appOptions.files {
 		totalCount += parsePath(aFile, appOptions, handler)
+		if appOptions.quiet && totalCount > 0 {
+			break
+		}
 	}
 
 	if appOptions.count && !appOptions.quiet {
@@ -103,6 +187,9 @@ func parsePath(rootPath string, appOptions AppOptions, handler book.FoundHandler
 	}
 
 	filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+		if appOptions.quiet && count > 0 {
+			return errQuietFound
+		}
 		if info.IsDir() {
 			if !os.SameFile(fstat, info) {
 				count += parsePath(path, appOptions, handler)
@@ -130,7 +217,28 @@ func parseFile(file string, appOptions AppOptions, handler book.FoundHandler) bo
 		AfterContextLength:  appOptions.afterContext,
 		BeforeContextLength: appOptions.beforeContext,
 		IgnoreCase:          appOptions.ignoreCase,
+		IgnoreCases:         appOptions.ignoreCases,
 		FixedStrings:        appOptions.fixedStrings,
+		InvertMatch:         appOptions.invertMatch,
+		WordRegexp:          appOptions.wordRegexp,
+		LineRegexp:          appOptions.lineRegexp,
+		PatternLabels:       appOptions.patternLabels,
+		PrintPatternLabel:   appOptions.patternLabel,
+		Expression:          appOptions.expression,
+		AllMatch:            appOptions.allMatch,
+		Multiline:           appOptions.multiline,
+		MaxErrors:           appOptions.maxErrors,
+		PerlRegexp:          appOptions.perlRegexp,
+		LongestMatch:        appOptions.basicRegexp || appOptions.extendedRegexp,
+		MaxCount:            appOptions.maxCount,
+		TypedMatchers:       appOptions.typedMatchers,
+		Fields:              appOptions.fieldParams,
+		JSON:                appOptions.jsonParams,
+		Logfmt:              appOptions.logfmtParams,
+		TimeRange:           appOptions.timeRangeParams,
+		HexPatterns:         appOptions.hexPatterns,
+		StopOnFirstMatch:    appOptions.quiet || ((appOptions.filesWithMatches || appOptions.filesWithoutMatch) && !appOptions.count),
 		Handler:             handler,
+		ErrorHandler:        book.StderrErrorHandler,
 	})
 }
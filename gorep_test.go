@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNewAppInvertMatch(t *testing.T) {
+	appOptions = AppOptions{}
+	defer func() {
+		appOptions = AppOptions{}
+	}()
+	if err := newApp().Run([]string{AppName, "-v", "foo"}); err != nil {
+		t.Fatal("Run returned an error.", err)
+	}
+	if !appOptions.invertMatch || len(appOptions.patterns) != 1 || appOptions.patterns[0] != "foo" {
+		t.Error("-v should be --invert-match.", appOptions.invertMatch, appOptions.patterns)
+	}
+}